@@ -1,17 +1,28 @@
 package edgex
 
-import "strings"
+import (
+	"errors"
+	"strings"
+)
 
 //
 // Author: 陈哈哈 yoojiachen@gmail.com
 //
 
-// checkIDFormat 检查命名规则，不允许带/符号。
-func checkIDFormat(id, keyName string) string {
+// verifyIDFormat 检查命名规则，不允许带 '/' 或 ':' 符号；不符合时返回错误
+func verifyIDFormat(id, keyName string) error {
 	if strings.Contains(id, "/") || strings.Contains(id, ":") {
-		log.Panic(keyName + "中不能包含 '/' 或 ':' 字符:" + id)
+		return errors.New(keyName + "中不能包含 '/' 或 ':' 字符:" + id)
 	}
-	return id
+	return nil
+}
+
+// verifyRequiredId 检查ID是否有效，无效时返回错误
+func verifyRequiredId(id, keyName string) error {
+	if "" == id {
+		return errors.New(keyName + "是必须的参数")
+	}
+	return verifyIDFormat(id, keyName)
 }
 
 // checkRequired 检查配置值是否有效；无效则Panic；
@@ -22,7 +33,10 @@ func checkRequired(value, message string) string {
 	return value
 }
 
+// checkRequiredId 检查ID是否有效；无效则Panic；
 func checkRequiredId(id, keyName string) string {
-	checkRequired(id, keyName+"是必须的参数")
-	return checkIDFormat(id, keyName)
+	if err := verifyRequiredId(id, keyName); nil != err {
+		log.Panic(err.Error())
+	}
+	return id
 }
@@ -0,0 +1,61 @@
+package edgex
+
+import (
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+// Clock 是定时任务使用的时钟接口。默认使用系统时钟，测试中可替换为可控时钟。
+type Clock interface {
+	// Now 返回当前时间
+	Now() time.Time
+
+	// NewTicker 创建按指定周期触发的Ticker
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker 周期触发器接口
+type Ticker interface {
+	// C 返回周期触发的通道
+	C() <-chan time.Time
+
+	// Stop 停止触发
+	Stop()
+}
+
+// WithClock 指定Context及其组件使用的时钟。默认使用系统时钟。
+func WithClock(clock Clock) ContextOption {
+	return func(c *NodeContext) {
+		c.clock = clock
+	}
+}
+
+// SystemClock 返回系统时钟
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTicker(d time.Duration) Ticker {
+	return &systemTicker{ticker: time.NewTicker(d)}
+}
+
+type systemTicker struct {
+	ticker *time.Ticker
+}
+
+func (t *systemTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t *systemTicker) Stop() {
+	t.ticker.Stop()
+}
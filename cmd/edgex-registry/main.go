@@ -0,0 +1,47 @@
+// edgex-registry 订阅全部EdgeX节点的Properties及State消息，并通过HTTP/JSON接口提供节点查询。
+//
+// Broker等连接参数通过 EDGEX_MQTT_* 环境变量设置。
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/nextabc-lab/edgex-go"
+	"github.com/nextabc-lab/edgex-go/registry"
+	"net/http"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func main() {
+	nodeId := flag.String("node", "EDGEX-REGISTRY", "注册中心的节点ID")
+	listen := flag.String("listen", "127.0.0.1:5580", "HTTP接口的监听地址")
+	timeout := flag.Duration("timeout", 0, "判定节点离线的超时时长，为0时按节点心跳周期计算")
+	evict := flag.Duration("evict", registry.DefaultEvictAfter, "移除离线节点的时长，小于0时不移除")
+	flag.Parse()
+
+	edgex.Run(func(ctx edgex.Context) error {
+		ctx.Initial(*nodeId)
+
+		reg := registry.New(registry.Options{NodeTimeout: *timeout, EvictAfter: *evict})
+		if err := reg.Attach(ctx); nil != err {
+			return err
+		}
+
+		server := &http.Server{Addr: *listen, Handler: reg}
+		go func() {
+			if err := server.ListenAndServe(); nil != err && http.ErrServerClosed != err {
+				ctx.Log().Error("HTTP服务出错：", err)
+			}
+		}()
+		ctx.Log().Infof("注册中心HTTP接口：http://%s/nodes", *listen)
+
+		_ = ctx.TermAwait()
+		shutdown, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		return server.Shutdown(shutdown)
+	})
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/nextabc-lab/edgex-go"
+	"os"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func runCall(args []string) error {
+	fs := newFlagSet("call")
+	nodeId := fs.String("node", defaultNodeId(), "当前工具使用的节点ID")
+	timeout := fs.Duration("timeout", edgex.DefaultDriverCallTimeout, "等待响应的超时时间")
+	typeName := fs.String("type", "text", "请求消息体的内容类型：raw/text/json/protobuf")
+	_ = fs.Parse(args)
+	if fs.NArg() < 4 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	contentType, err := parseContentType(*typeName)
+	if nil != err {
+		return err
+	}
+	body, err := readBody(fs.Args()[4:])
+	if nil != err {
+		return err
+	}
+	executor := fs.Arg(0)
+	return run(*nodeId, func(ctx edgex.Context) error {
+		driver := ctx.NewDriver(edgex.DriverOptions{CallTimeout: *timeout})
+		driver.Startup()
+		defer driver.Shutdown()
+		req := driver.NewRequest(executor, fs.Arg(1), fs.Arg(2), fs.Arg(3), body, edgex.WithContentType(contentType))
+		callCtx, cancel := termContext(ctx)
+		defer cancel()
+		start := time.Now()
+		reply, err := driver.Call(callCtx, executor, req)
+		if nil != err {
+			return err
+		}
+		ctx.Log().Debugf("RPC响应耗时：%s", time.Since(start))
+		writeMessage(os.Stdout, "reply", reply)
+		return nil
+	})
+}
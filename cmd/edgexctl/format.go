@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"github.com/nextabc-lab/edgex-go"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+var contentTypes = map[string]byte{
+	"raw":      edgex.ContentTypeRaw,
+	"text":     edgex.ContentTypeText,
+	"json":     edgex.ContentTypeJSON,
+	"protobuf": edgex.ContentTypeProtobuf,
+}
+
+// parseContentType 解析命令行参数中的消息体内容类型
+func parseContentType(name string) (byte, error) {
+	if contentType, ok := contentTypes[strings.ToLower(name)]; ok {
+		return contentType, nil
+	}
+	return 0, fmt.Errorf("未知的消息体内容类型：%s", name)
+}
+
+func contentTypeName(contentType byte) string {
+	for name, ct := range contentTypes {
+		if ct == contentType {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// readBody 读取命令行参数中的消息体；参数为"-"时从标准输入读取
+func readBody(args []string) ([]byte, error) {
+	if 0 == len(args) {
+		return []byte{}, nil
+	}
+	if "-" == args[0] {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return []byte(args[0]), nil
+}
+
+// writeMessage 输出解码后的消息，kind为消息类别
+func writeMessage(w io.Writer, kind string, msg edgex.Message) {
+	header := msg.Header()
+	fmt.Fprintf(w, "%s [%s] %s event=%d v%d type=%s",
+		time.Now().Format("15:04:05.000"), kind, msg.UnionId(), msg.EventId(), header.Version, contentTypeName(msg.ContentType()))
+	if 0 != header.Timestamp {
+		fmt.Fprintf(w, " ts=%s", msg.Timestamp().Format(time.RFC3339Nano))
+	}
+	if 0 < msg.TTL() {
+		fmt.Fprintf(w, " ttl=%s", msg.TTL())
+	}
+	if "" != msg.ReplyTo() {
+		fmt.Fprintf(w, " replyTo=%s", msg.ReplyTo())
+	}
+	fmt.Fprintf(w, "\n\t%s\n", formatBody(msg.ContentType(), msg.Body()))
+}
+
+// formatBody 按内容类型格式化消息体：文本类型原样输出，二进制类型输出十六进制；未指定类型时按内容判断。
+func formatBody(contentType byte, body []byte) string {
+	switch contentType {
+	case edgex.ContentTypeText, edgex.ContentTypeJSON:
+		return string(body)
+
+	case edgex.ContentTypeRaw, edgex.ContentTypeProtobuf:
+		return "0x" + hex.EncodeToString(body)
+
+	default:
+		if isPrintable(body) {
+			return string(body)
+		}
+		return "0x" + hex.EncodeToString(body)
+	}
+}
+
+func isPrintable(body []byte) bool {
+	if !utf8.Valid(body) {
+		return false
+	}
+	for _, r := range string(body) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
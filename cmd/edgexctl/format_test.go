@@ -0,0 +1,29 @@
+package main
+
+import (
+	"github.com/nextabc-lab/edgex-go"
+	"testing"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func TestFormatBody(t *testing.T) {
+	cases := []struct {
+		contentType byte
+		body        []byte
+		expected    string
+	}{
+		{edgex.ContentTypeJSON, []byte(`{"a":1}`), `{"a":1}`},
+		{edgex.ContentTypeRaw, []byte("AB"), "0x4142"},
+		{edgex.ContentTypeUnknown, []byte("hello\n"), "hello\n"},
+		{edgex.ContentTypeUnknown, []byte{0xED, 0x01}, "0xed01"},
+		{edgex.ContentTypeUnknown, []byte{0x00, 0x41}, "0x0041"},
+	}
+	for _, c := range cases {
+		if actual := formatBody(c.contentType, c.body); c.expected != actual {
+			t.Errorf("Body not match, expected: %q, was: %q", c.expected, actual)
+		}
+	}
+}
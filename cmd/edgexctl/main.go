@@ -0,0 +1,113 @@
+// edgexctl 是调试EdgeX消息总线的命令行工具：查看解码后的消息、列出节点、调用Endpoint及发送测试消息。
+//
+// Broker等连接参数通过 EDGEX_MQTT_* 环境变量设置。
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/nextabc-lab/edgex-go"
+	"os"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string) error
+}
+
+// commands 在init中赋值：各子命令通过 newFlagSet 引用commands，直接初始化将形成初始化循环
+var commands []command
+
+func init() {
+	commands = []command{
+		{"tail", "tail [-filter #] [events|values|actions|states|properties ...]", "订阅并输出解码后的消息，默认订阅events、values、actions及states", runTail},
+		{"nodes", "nodes [-wait 2s] [-json]", "请求全部节点上报Properties，并列出节点及虚拟节点", runNodes},
+		{"call", "call [-timeout 10s] [-type text] <nodeId> <boardId> <majorId> <minorId> [body|-]", "向Endpoint发起RPC调用，并输出响应消息", runCall},
+		{"publish", "publish -node <nodeId> [-topic <topic>] [-kind event] [-qos 0] [-count 1] <boardId> <majorId> <minorId> [body|-]", "发送指定节点ID的测试消息，event/value消息须指定-topic", runPublish},
+	}
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if 0 == flag.NArg() {
+		usage()
+		os.Exit(2)
+	}
+	for _, cmd := range commands {
+		if cmd.name == flag.Arg(0) {
+			if err := cmd.run(flag.Args()[1:]); nil != err {
+				fmt.Fprintln(os.Stderr, "edgexctl:", err)
+				os.Exit(1)
+			}
+			return
+		}
+	}
+	fmt.Fprintf(os.Stderr, "edgexctl: 未知命令：%s\n", flag.Arg(0))
+	usage()
+	os.Exit(2)
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "用法：edgexctl <command> [options]")
+	fmt.Fprintln(os.Stderr)
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %s\n\t%s\n", cmd.usage, cmd.summary)
+	}
+}
+
+// newFlagSet 创建子命令的参数解析器
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet("edgexctl "+name, flag.ExitOnError)
+	for _, cmd := range commands {
+		if cmd.name == name {
+			fs.Usage = func() {
+				fmt.Fprintf(os.Stderr, "用法：edgexctl %s\n", cmd.usage)
+				fs.PrintDefaults()
+			}
+		}
+	}
+	return fs
+}
+
+// defaultNodeId 返回命令行工具默认使用的节点ID，包含进程ID以免多个实例冲突
+func defaultNodeId() string {
+	return fmt.Sprintf("EDGEXCTL-%d", os.Getpid())
+}
+
+// run 使用环境变量中的连接参数运行EdgeX客户端，返回application的错误。
+// 工具不作为节点出现：不发送节点状态、遗嘱及属性消息，不响应Discovery请求。
+func run(nodeId string, application func(ctx edgex.Context) error) error {
+	var err error
+	edgex.Run(func(ctx edgex.Context) error {
+		ctx.InitialWithConfig(map[string]interface{}{
+			"NodeId": nodeId,
+			"Globals": map[string]interface{}{
+				"NodeStateDisabled": true,
+			},
+		})
+		err = application(ctx)
+		return err
+	})
+	return err
+}
+
+// termContext 返回接收到系统终止信号时取消的context
+func termContext(ctx edgex.Context) (context.Context, context.CancelFunc) {
+	termCtx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-ctx.TermChan():
+			cancel()
+		case <-termCtx.Done():
+		}
+	}()
+	return termCtx, cancel
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/nextabc-lab/edgex-go"
+	"github.com/nextabc-lab/edgex-go/registry"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func runNodes(args []string) error {
+	fs := newFlagSet("nodes")
+	nodeId := fs.String("node", defaultNodeId(), "当前工具使用的节点ID")
+	wait := fs.Duration("wait", time.Second*2, "等待节点上报Properties的时长")
+	asJSON := fs.Bool("json", false, "以JSON格式输出")
+	_ = fs.Parse(args)
+	return run(*nodeId, func(ctx edgex.Context) error {
+		reg := registry.New(registry.Options{})
+		if err := reg.Attach(ctx); nil != err {
+			return err
+		}
+		select {
+		case <-time.After(*wait):
+		case <-ctx.TermChan():
+		}
+		nodes := make([]registry.Node, 0)
+		for _, node := range reg.Nodes() {
+			if *nodeId != node.NodeId {
+				nodes = append(nodes, node)
+			}
+		}
+		if *asJSON {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			return encoder.Encode(nodes)
+		}
+		writeNodes(nodes)
+		return nil
+	})
+}
+
+func writeNodes(nodes []registry.Node) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "NODE\tTYPE\tSTATUS\tVERSION\tLAST SEEN")
+	for _, node := range nodes {
+		nodeType, status, version := "-", "OFFLINE", "-"
+		if nil != node.Properties && "" != node.Properties.NodeType {
+			nodeType = node.Properties.NodeType
+		}
+		if node.Online {
+			status = "ONLINE"
+		}
+		if nil != node.State && "" != node.State.Version {
+			version = node.State.Version
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", node.NodeId, nodeType, status, version, node.LastSeen.Format(time.RFC3339))
+		for _, vn := range node.VirtualNodes {
+			deviceType, state := "-", "-"
+			if nil != vn.Properties && "" != vn.Properties.DeviceType {
+				deviceType = vn.Properties.DeviceType
+			}
+			if nil != vn.State && "" != vn.State.State {
+				state = vn.State.State
+			}
+			fmt.Fprintf(w, "  %s\t%s\t%s\t\t%s\n", vn.UnionId, deviceType, state, vn.LastSeen.Format(time.RFC3339))
+		}
+	}
+}
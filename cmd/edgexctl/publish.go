@@ -0,0 +1,94 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/nextabc-lab/edgex-go"
+	"os"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+// publishFunc 发送nodeId节点的消息。Action主题以发送节点ID区分，不能使用Trigger所属节点的主题。
+type publishFunc func(trigger edgex.Trigger, nodeId string, message edgex.Message, opts ...edgex.PublishOption) error
+
+var publishKinds = map[string]publishFunc{
+	"event": func(trigger edgex.Trigger, nodeId string, message edgex.Message, opts ...edgex.PublishOption) error {
+		return trigger.PublishEventMessage(message, opts...)
+	},
+	"value": func(trigger edgex.Trigger, nodeId string, message edgex.Message, opts ...edgex.PublishOption) error {
+		return trigger.PublishValueMessage(message, opts...)
+	},
+	"action": func(trigger edgex.Trigger, nodeId string, message edgex.Message, opts ...edgex.PublishOption) error {
+		options := edgex.PublishOptions{}
+		for _, opt := range opts {
+			opt(&options)
+		}
+		return trigger.PublishMqtt(edgex.TopicOfActions(nodeId), message, options.QoS, false)
+	},
+}
+
+// runPublish 使用工具自身的节点连接Broker，仅在消息中使用指定的节点ID，
+// 不以该节点身份连接，也不发送该节点的状态、遗嘱及心跳消息。
+func runPublish(args []string) error {
+	fs := newFlagSet("publish")
+	nodeId := fs.String("node", "", "消息中使用的节点ID（必填）")
+	topic := fs.String("topic", "", "Trigger的Topic，event/value消息必填")
+	kind := fs.String("kind", "event", "消息类别：event/value/action")
+	typeName := fs.String("type", "text", "消息体的内容类型：raw/text/json/protobuf")
+	qos := fs.Uint("qos", 0, "消息的QoS：0~2，未指定时event/value消息使用默认设置")
+	count := fs.Int("count", 1, "发送消息的数量")
+	interval := fs.Duration("interval", time.Second, "连续发送消息的间隔")
+	_ = fs.Parse(args)
+	if "" == *nodeId || fs.NArg() < 3 || ("action" != *kind && "" == *topic) || *qos > 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	publish, ok := publishKinds[*kind]
+	if !ok {
+		return fmt.Errorf("未知的消息类别：%s", *kind)
+	}
+	contentType, err := parseContentType(*typeName)
+	if nil != err {
+		return err
+	}
+	body, err := readBody(fs.Args()[3:])
+	if nil != err {
+		return err
+	}
+	opts := make([]edgex.PublishOption, 0)
+	fs.Visit(func(f *flag.Flag) {
+		if "qos" == f.Name {
+			opts = append(opts, edgex.WithQoS(uint8(*qos)))
+		}
+	})
+	if "" == *topic {
+		// Action消息发送到 TopicOfActions(nodeId)，Trigger的Topic不被使用
+		*topic = *nodeId
+	}
+	return run(defaultNodeId(), func(ctx edgex.Context) error {
+		trigger := ctx.NewTrigger(edgex.TriggerOptions{Topic: *topic})
+		trigger.Startup()
+		defer trigger.Shutdown()
+		for i := 0; i < *count; i++ {
+			if 0 < i {
+				select {
+				case <-time.After(*interval):
+				case <-ctx.TermChan():
+					return nil
+				}
+			}
+			// 使用v2帧格式，以便消息携带内容类型
+			msg := edgex.NewMessage(*nodeId, fs.Arg(0), fs.Arg(1), fs.Arg(2), body, trigger.GenerateEventId(),
+				edgex.WithFrameVersion(edgex.FrameVersionV2), edgex.WithContentType(contentType))
+			if err := publish(trigger, *nodeId, msg, opts...); nil != err {
+				return err
+			}
+			writeMessage(os.Stdout, *kind, msg)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"github.com/nextabc-lab/edgex-go"
+	"os"
+	"sync"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+type subscribeFunc func(ctx edgex.Context, filter string, handler edgex.MessageHandler) error
+
+var tailKinds = map[string]subscribeFunc{
+	"events":     edgex.Context.SubscribeEvents,
+	"values":     edgex.Context.SubscribeValues,
+	"actions":    edgex.Context.SubscribeActions,
+	"states":     edgex.Context.SubscribeStates,
+	"properties": edgex.Context.SubscribeProperties,
+}
+
+func runTail(args []string) error {
+	fs := newFlagSet("tail")
+	nodeId := fs.String("node", defaultNodeId(), "当前工具使用的节点ID")
+	filter := fs.String("filter", "#", "订阅过滤条件：events/values为Trigger的Topic，其它为节点ID；支持MQTT通配符")
+	_ = fs.Parse(args)
+	kinds := fs.Args()
+	if 0 == len(kinds) {
+		kinds = []string{"events", "values", "actions", "states"}
+	}
+	for _, kind := range kinds {
+		if _, ok := tailKinds[kind]; !ok {
+			return fmt.Errorf("未知的消息类别：%s", kind)
+		}
+	}
+	return run(*nodeId, func(ctx edgex.Context) error {
+		// 各订阅的处理函数可能并发调用，输出时加锁以免内容交错
+		var mutex sync.Mutex
+		for _, kind := range kinds {
+			kind := kind
+			err := tailKinds[kind](ctx, *filter, func(msg edgex.Message) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				writeMessage(os.Stdout, kind, msg)
+			})
+			if nil != err {
+				return err
+			}
+		}
+		return ctx.TermAwait()
+	})
+}
@@ -1,18 +1,20 @@
 package edgex
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/BurntSushi/toml"
 	"github.com/bwmarrin/snowflake"
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/yoojia/go-value"
 	"go.uber.org/zap"
 	"net"
 	"os"
 	"os/signal"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -24,9 +26,13 @@ import (
 // Context 是一个提供基础通讯环境和参数设置的对象。通过Context来创建Trigger, Endpoint, Driver组件，并为组件提供MQTT通讯能力。
 type Context interface {
 	NeedAccessNodeId
-	// 使用默认配置结构来初化Context
+	// 使用默认配置结构来初化Context。初始化失败时Panic。
 	InitialWithConfig(config map[string]interface{})
 
+	// InitialE 使用默认配置结构来初化Context，初始化失败时返回错误。
+	// 连接Broker期间，ctx被取消或接收到系统中断退出信号时立即返回错误。
+	InitialE(ctx context.Context, config map[string]interface{}) error
+
 	// 初化和设置Context
 	Initial(nodeId string)
 
@@ -52,6 +58,36 @@ type Context interface {
 	// NewEndpoint 创建Endpoint对象，并绑定Context为Endpoint节点。
 	NewEndpoint(opts EndpointOptions) Endpoint
 
+	// NewDriver 创建Driver对象，并绑定Context为Driver节点。
+	NewDriver(opts DriverOptions) Driver
+
+	// SubscribeEvents 订阅Trigger的Event消息。topicFilter为Trigger的Topic，支持MQTT通配符 '+' 和 '#'。
+	SubscribeEvents(topicFilter string, handler MessageHandler) error
+
+	// SubscribeValues 订阅Trigger的Value消息。topicFilter为Trigger的Topic，支持MQTT通配符 '+' 和 '#'。
+	SubscribeValues(topicFilter string, handler MessageHandler) error
+
+	// SubscribeActions 订阅节点的Action消息。nodeIdFilter为节点ID，支持MQTT通配符 '+' 和 '#'。
+	SubscribeActions(nodeIdFilter string, handler MessageHandler) error
+
+	// SubscribeStates 订阅节点的State消息，包括主节点状态及各虚拟节点状态。nodeIdFilter为节点ID，支持MQTT通配符 '+' 和 '#'。
+	SubscribeStates(nodeIdFilter string, handler MessageHandler) error
+
+	// SubscribeProperties 订阅节点的Properties消息。nodeIdFilter为节点ID，支持MQTT通配符 '+' 和 '#'。
+	SubscribeProperties(nodeIdFilter string, handler MessageHandler) error
+
+	// ConnectedBroker 返回当前已连接的Broker地址，未连接时返回空字符串
+	ConnectedBroker() string
+
+	// OfflineStats 返回离线消息队列的统计数据；未启用离线队列时返回零值
+	OfflineStats() OfflineQueueStats
+
+	// ReportError 记录最近一次错误，随心跳消息发送
+	ReportError(err error)
+
+	// RequestDiscovery 请求节点重新上报Properties消息；nodeId为空时请求全部节点。
+	RequestDiscovery(nodeId string) error
+
 	// TermChan 返回监听系统中断退出信号的通道
 	TermChan() <-chan os.Signal
 
@@ -76,9 +112,17 @@ const (
 	EnvKeyMQQoS          = "EDGEX_MQTT_QOS"
 	EnvKeyMQRetained     = "EDGEX_MQTT_RETAINED"
 	EnvKeyMQCleanSession = "EDGEX_MQTT_CLEAN_SESSION"
+	EnvKeyMQCAFile       = "EDGEX_MQTT_CA_FILE"
+	EnvKeyMQCertFile     = "EDGEX_MQTT_CERT_FILE"
+	EnvKeyMQKeyFile      = "EDGEX_MQTT_KEY_FILE"
+	EnvKeyMQServerName   = "EDGEX_MQTT_SERVER_NAME"
+	EnvKeyMQInsecureSkip = "EDGEX_MQTT_INSECURE_SKIP_VERIFY"
 	EnvKeyConfig         = "EDGEX_CONFIG"
 	EnvKeyLogVerbose     = "EDGEX_LOG_VERBOSE"
 	EnvKeyMachineId      = "EDGEX_MACHINE_ID"
+	EnvKeyFrameVersion   = "EDGEX_FRAME_VERSION"
+	EnvKeyOfflineDir     = "EDGEX_OFFLINE_QUEUE_DIR"
+	EnvKeyNodeVersion    = "EDGEX_NODE_VERSION"
 
 	DefaultMqttBroker = "tcp://mqtt-broker.edgex.io:1883"
 	DefaultConfName   = "application.toml"
@@ -104,58 +148,107 @@ func Run(application func(ctx Context) error) {
 	}
 }
 
+// DestroyContext 释放Context资源：停止心跳，取消订阅，发送OFFLINE状态并断开连接；重复调用无效果。
+// Run 运行结束时自动调用；直接使用 CreateContext 创建的Context（如测试中）须自行调用。
+func DestroyContext(ctx Context) {
+	ctx.destroy()
+}
+
 // CreateContext 使用指定 Globals 参数，创建Context对象。
-func CreateContext(globals *Globals) Context {
-	return newContext(globals)
+func CreateContext(globals *Globals, opts ...ContextOption) Context {
+	return newContext(globals, opts...)
 }
 
 // CreateDefaultContext 从环境变量中读取 Globals 参数，并创建返回Context对象。
-func CreateDefaultContext() Context {
+func CreateDefaultContext(opts ...ContextOption) Context {
 	return CreateContext(&Globals{
-		MqttBroker:            EnvGetString(EnvKeyMQBroker, DefaultMqttBroker),
-		MqttUsername:          EnvGetString(EnvKeyMQUsername, ""),
-		MqttPassword:          EnvGetString(EnvKeyMQPassword, ""),
-		MqttQoS:               uint8(EnvGetInt64(EnvKeyMQQoS, 0)),
-		MqttRetained:          EnvGetBoolean(EnvKeyMQRetained, false),
-		MqttCleanSession:      EnvGetBoolean(EnvKeyMQCleanSession, true),
-		MqttKeepAlive:         time.Second * 3,
-		MqttPingTimeout:       time.Second * 1,
-		MqttConnectTimeout:    time.Second * 5,
-		MqttReconnectInterval: time.Second * 1,
-		MqttAutoReconnect:     true,
-		MqttMaxRetry:          120,
-		MqttQuitMillSec:       500,
-		LogVerbose:            EnvGetBoolean(EnvKeyLogVerbose, false),
-	})
+		MqttBroker:             EnvGetString(EnvKeyMQBroker, DefaultMqttBroker),
+		MqttUsername:           EnvGetString(EnvKeyMQUsername, ""),
+		MqttPassword:           EnvGetString(EnvKeyMQPassword, ""),
+		MqttQoS:                uint8(EnvGetInt64(EnvKeyMQQoS, 0)),
+		MqttRetained:           EnvGetBoolean(EnvKeyMQRetained, false),
+		MqttCleanSession:       EnvGetBoolean(EnvKeyMQCleanSession, true),
+		MqttKeepAlive:          time.Second * 3,
+		MqttPingTimeout:        time.Second * 1,
+		MqttConnectTimeout:     time.Second * 5,
+		MqttReconnectInterval:  time.Second * 1,
+		MqttAutoReconnect:      true,
+		MqttMaxRetry:           120,
+		MqttMaxRetryInterval:   time.Second * 30,
+		MqttPublishTimeout:     time.Second * 5,
+		MqttQuitMillSec:        500,
+		MqttCAFile:             EnvGetString(EnvKeyMQCAFile, ""),
+		MqttCertFile:           EnvGetString(EnvKeyMQCertFile, ""),
+		MqttKeyFile:            EnvGetString(EnvKeyMQKeyFile, ""),
+		MqttServerName:         EnvGetString(EnvKeyMQServerName, ""),
+		MqttInsecureSkipVerify: EnvGetBoolean(EnvKeyMQInsecureSkip, false),
+		OfflineQueueDir:        EnvGetString(EnvKeyOfflineDir, ""),
+		OfflineQueueMaxBytes:   DefaultOfflineQueueMaxBytes,
+		OfflineQueueMaxAge:     time.Hour * 24,
+		NodeStateQoS:           1,
+		NodeStateRetained:      true,
+		HeartbeatInterval:      DefaultHeartbeatInterval,
+		PropertiesInterval:     DefaultPropertiesInterval,
+		PropertiesDuration:     DefaultPropertiesDuration,
+		NodeVersion:            EnvGetString(EnvKeyNodeVersion, ""),
+		FrameVersion:           byte(EnvGetInt64(EnvKeyFrameVersion, FrameVersion)),
+		LogVerbose:             EnvGetBoolean(EnvKeyLogVerbose, false),
+	}, opts...)
 }
 
+// 订阅消息处理函数
+type MessageHandler func(msg Message)
+
 //// Context实现
 
 type NodeContext struct {
-	globals    *Globals
-	nodeId     string
-	mqttClient mqtt.Client
-	signals    chan os.Signal
-	eventId    *snowflake.Node
-	attrs      *sync.Map
+	globals          *Globals
+	nodeId           string
+	transportFactory TransportFactory
+	transport        Transport
+	offline          *offlineQueue
+	clock            Clock
+	signals          chan os.Signal
+	eventId          *snowflake.Node
+	attrs            *sync.Map
+	subTopics        *sync.Map // 通过Subscribe*接口订阅的MQTT Topic
+	retainedTopics   *sync.Map // 已发送的节点状态及属性保留消息Topic，正常退出时清除
+	announcers       *sync.Map // Trigger/Endpoint注册的Properties上报函数
+	announceRunning  int32     // Discovery请求触发的上报协程是否运行中
+	announcePending  int32     // 是否有等待处理的Discovery请求
+	// Heartbeat
+	startTime       time.Time
+	lastError       atomic.Value
+	heartbeatCancel context.CancelFunc
+	destroyOnce     sync.Once
 }
 
 func (c *NodeContext) InitialWithConfig(config map[string]interface{}) {
+	if err := c.InitialE(context.Background(), config); nil != err {
+		log.Panic("Context初始化出错：", err)
+	}
+}
+
+func (c *NodeContext) InitialE(ctx context.Context, config map[string]interface{}) error {
 	log.Debug("Context Initial")
-	// Signals
+	// Signals：初始化成功后才注册监听，出错返回时无须注销
 	c.signals = make(chan os.Signal, 1)
-	signal.Notify(c.signals, syscall.SIGTERM, syscall.SIGINT)
 
 	c.nodeId = value.ToString(config["NodeId"])
-	checkRequiredId(c.nodeId, "NodeId")
+	if err := verifyRequiredId(c.nodeId, "NodeId"); nil != err {
+		return err
+	}
 	node, err := snowflake.NewNode(findMachineId())
 	if nil != err {
-		log.Panic("创建ID生成器出错：", err)
+		return fmt.Errorf("创建ID生成器出错：%s", err)
 	} else {
 		c.eventId = node
 	}
 	log.Debugf("EventId Generator, TestId: %d", c.eventId.Generate().Int64())
 	c.attrs = new(sync.Map)
+	c.subTopics = new(sync.Map)
+	c.retainedTopics = new(sync.Map)
+	c.announcers = new(sync.Map)
 
 	// Globals设置
 	if globals, ok := value.ToMap(config["Globals"]); ok {
@@ -163,10 +256,40 @@ func (c *NodeContext) InitialWithConfig(config map[string]interface{}) {
 		if flag, ok := value.ToBool(globals["LogVerbose"]); ok {
 			c.globals.LogVerbose = flag
 		}
+		if iv, ok := value.ToInt64(globals["FrameVersion"]); ok {
+			c.globals.FrameVersion = byte(iv)
+		}
+		if iv, ok := value.ToInt64(globals["NodeStateQoS"]); ok {
+			c.globals.NodeStateQoS = uint8(iv)
+		}
+		if flag, ok := value.ToBool(globals["NodeStateRetained"]); ok {
+			c.globals.NodeStateRetained = flag
+		}
+		if du, ok := value.ToDuration(globals["PropertiesInterval"]); ok {
+			c.globals.PropertiesInterval = du
+		}
+		if du, ok := value.ToDuration(globals["PropertiesDuration"]); ok {
+			c.globals.PropertiesDuration = du
+		}
+		if du, ok := value.ToDuration(globals["HeartbeatInterval"]); ok {
+			c.globals.HeartbeatInterval = du
+		}
+		if flag, ok := value.ToBool(globals["NodeStateDisabled"]); ok {
+			c.globals.NodeStateDisabled = flag
+		}
+		if str, ok := value.ToStringB(globals["NodeVersion"]); ok {
+			c.globals.NodeVersion = str
+		}
 		// MQTT配置
 		if str, ok := value.ToStringB(globals["MqttBroker"]); ok {
 			c.globals.MqttBroker = str
 		}
+		if list, ok := globals["MqttBrokers"].([]interface{}); ok {
+			c.globals.MqttBrokers = make([]string, 0, len(list))
+			for _, url := range list {
+				c.globals.MqttBrokers = append(c.globals.MqttBrokers, value.ToString(url))
+			}
+		}
 		if str, ok := value.ToStringB(globals["MqttUsername"]); ok {
 			c.globals.MqttUsername = str
 		}
@@ -200,32 +323,138 @@ func (c *NodeContext) InitialWithConfig(config map[string]interface{}) {
 		if iv, ok := value.ToInt64(globals["MqttMaxRetry"]); ok {
 			c.globals.MqttMaxRetry = int(iv)
 		}
+		if du, ok := value.ToDuration(globals["MqttMaxRetryInterval"]); ok {
+			c.globals.MqttMaxRetryInterval = du
+		}
+		if du, ok := value.ToDuration(globals["MqttPublishTimeout"]); ok {
+			c.globals.MqttPublishTimeout = du
+		}
 		if iv, ok := value.ToInt64(globals["MqttQuitMillSec"]); ok {
 			c.globals.MqttQuitMillSec = uint(iv)
 		}
+		// TLS配置
+		if str, ok := value.ToStringB(globals["MqttCAFile"]); ok {
+			c.globals.MqttCAFile = str
+		}
+		if str, ok := value.ToStringB(globals["MqttCertFile"]); ok {
+			c.globals.MqttCertFile = str
+		}
+		if str, ok := value.ToStringB(globals["MqttKeyFile"]); ok {
+			c.globals.MqttKeyFile = str
+		}
+		if str, ok := value.ToStringB(globals["MqttServerName"]); ok {
+			c.globals.MqttServerName = str
+		}
+		if flag, ok := value.ToBool(globals["MqttInsecureSkipVerify"]); ok {
+			c.globals.MqttInsecureSkipVerify = flag
+		}
+		// 离线队列配置
+		if str, ok := value.ToStringB(globals["OfflineQueueDir"]); ok {
+			c.globals.OfflineQueueDir = str
+		}
+		if iv, ok := value.ToInt64(globals["OfflineQueueMaxBytes"]); ok {
+			c.globals.OfflineQueueMaxBytes = iv
+		}
+		if du, ok := value.ToDuration(globals["OfflineQueueMaxAge"]); ok {
+			c.globals.OfflineQueueMaxAge = du
+		}
+		if str, ok := value.ToStringB(globals["OfflineDropPolicy"]); ok {
+			switch str {
+			case "oldest":
+				c.globals.OfflineDropPolicy = OfflineDropOldest
+			case "newest":
+				c.globals.OfflineDropPolicy = OfflineDropNewest
+			default:
+				return fmt.Errorf("不支持的离线队列丢弃策略：%s", str)
+			}
+		}
+	}
+	if err := verifyFrameVersion(c.globals.FrameVersion); nil != err {
+		return err
+	}
+	// TLS配置只创建一次，证书文件错误无须重试连接
+	if nil == c.globals.MqttTLSConfig {
+		config, err := mqttTLSConfig(c.globals)
+		if nil != err {
+			return fmt.Errorf("MQTT TLS配置出错：%s", err)
+		}
+		c.globals.MqttTLSConfig = config
+	}
+	// 离线队列
+	if "" != c.globals.OfflineQueueDir {
+		queue, err := openOfflineQueue(c.globals, c.clock)
+		if nil != err {
+			return fmt.Errorf("打开离线队列出错：%s", err)
+		}
+		c.offline = queue
 	}
 	// MQTT Broker
-	opts := mqtt.NewClientOptions()
 	clientId := fmt.Sprintf("%s:%s", MqttClientIdHeader, c.nodeId)
-	opts.SetClientID(clientId)
+	transport := c.transportFactory(clientId, c.globals)
 
-	stateTopic := TopicOfStates(c.nodeId)
-	opts.SetWill(stateTopic, "OFFLINE", 0, false)
-	mqttSetOptions(opts, c.globals, func(client mqtt.Client) {
-		token := client.Publish(stateTopic, 0, false, "ALIVE")
-		if token.Wait() && nil != token.Error() {
-			log.Error("Mqtt客户端连接通知出错：", token.Error())
+	c.startTime = c.clock.Now()
+	if !c.globals.NodeStateDisabled {
+		transport.SetWill(TopicOfStates(c.nodeId),
+			createMainStateMessage(c.globals, c.mainNodeState(NodeStateOffline)).Bytes(),
+			c.globals.NodeStateQoS, c.globals.NodeStateRetained)
+	}
+	transport.OnConnected(func() {
+		if !c.globals.NodeStateDisabled {
+			if err := c.sendNodeState(transport, NodeStateAlive); nil != err {
+				log.Error("Mqtt客户端连接通知出错：", err)
+			}
 		}
+		if nil != c.offline {
+			c.offline.startReplay(transport)
+		}
+		c.announce()
 	})
-	c.mqttClient = mqtt.NewClient(opts)
-	log.Infof("Mqtt客户端：Broker= %s，ClientId= %s", c.globals.MqttBroker, clientId)
-
-	// 连续重试
-	mqttAwaitConnection(c.mqttClient, c.globals.MqttMaxRetry)
-
-	if !c.mqttClient.IsConnected() {
-		log.Panic("Mqtt客户端连接无法连接Broker")
+	c.transport = transport
+	log.Infof("Mqtt客户端：Broker= %v，ClientId= %s", mqttBrokerList(c.globals), clientId)
+
+	// 连续重试，连接期间监听系统中断退出信号
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, syscall.SIGTERM, syscall.SIGINT)
+	defer signal.Stop(interrupts)
+	awaitCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case s := <-interrupts:
+			log.Debugf("连接Broker期间接收到系统终止信号: %v", s)
+			cancel()
+		case <-awaitCtx.Done():
+		}
+	}()
+	if err := mqttAwaitConnection(awaitCtx, c.transport, c.globals); nil != err {
+		c.transport.Disconnect(0)
+		c.transport = nil
+		return err
+	}
+	// 不作为节点出现时，不接收Discovery请求，不发送心跳
+	if c.globals.NodeStateDisabled {
+		signal.Notify(c.signals, syscall.SIGTERM, syscall.SIGINT)
+		return nil
 	}
+	// 接收Discovery请求
+	for _, topic := range []string{TopicOfDiscovery(""), TopicOfDiscovery(c.nodeId)} {
+		if err := c.transport.Subscribe(topic, c.globals.MqttQoS, func(topic string, payload []byte) {
+			log.Debugf("接收到Discovery请求，Topic：%s", topic)
+			c.requestAnnounce()
+		}); nil != err {
+			log.Error("订阅Discovery-Topic出错：", err)
+		} else {
+			c.subTopics.Store(topic, struct{}{})
+		}
+	}
+	// 心跳
+	if 0 < c.globals.HeartbeatInterval {
+		var heartbeat context.Context
+		heartbeat, c.heartbeatCancel = context.WithCancel(context.Background())
+		go c.scheduleHeartbeat(heartbeat, c.transport)
+	}
+	signal.Notify(c.signals, syscall.SIGTERM, syscall.SIGINT)
+	return nil
 }
 
 func (c *NodeContext) Initial(nodeId string) {
@@ -239,7 +468,45 @@ func (c *NodeContext) NodeId() string {
 }
 
 func (c *NodeContext) destroy() {
-	c.mqttClient.Disconnect(c.globals.MqttQuitMillSec)
+	c.destroyOnce.Do(c.release)
+}
+
+// release 释放Context资源，仅执行一次
+func (c *NodeContext) release() {
+	if nil != c.signals {
+		signal.Stop(c.signals)
+	}
+	if nil == c.transport {
+		return
+	}
+	if nil != c.offline {
+		c.offline.close()
+	}
+	if nil != c.heartbeatCancel {
+		c.heartbeatCancel()
+	}
+	topics := make([]string, 0)
+	c.subTopics.Range(func(topic, _ interface{}) bool {
+		topics = append(topics, topic.(string))
+		return true
+	})
+	if 0 < len(topics) {
+		if err := c.transport.Unsubscribe(topics...); nil != err {
+			log.Error("取消订阅Topic出错：", err)
+		}
+	}
+	// 正常退出时清除主节点、虚拟节点状态及属性的保留消息，并发送非保留的OFFLINE状态；
+	// 遗嘱消息仅在连接异常断开时由Broker发送
+	if c.transport.IsConnected() && !c.globals.NodeStateDisabled {
+		if c.globals.NodeStateRetained {
+			c.retainedTopics.Store(TopicOfStates(c.nodeId), struct{}{})
+		}
+		mqttClearRetained(c.globals, c.transport, c.retainedTopics)
+		if err := c.publishNodeState(c.transport, NodeStateOffline, false); nil != err {
+			log.Error("发送OFFLINE状态出错：", err)
+		}
+	}
+	c.transport.Disconnect(c.globals.MqttQuitMillSec)
 }
 
 func (c *NodeContext) LoadConfig() map[string]interface{} {
@@ -253,19 +520,50 @@ func (c *NodeContext) LoadConfigByName(fileName string) map[string]interface{} {
 func (c *NodeContext) NewTrigger(opts TriggerOptions) Trigger {
 	c.checkInit()
 	checkRequired(opts.Topic, "必须设置参数选项Trigger.Topic")
+	for name, defaults := range map[string]*PublishOptions{
+		"EventPublish":  opts.EventPublish,
+		"ValuePublish":  opts.ValuePublish,
+		"ActionPublish": opts.ActionPublish,
+	} {
+		if nil == defaults {
+			continue
+		}
+		if err := verifyPublishOptions(*defaults); nil != err {
+			log.Panicf("参数选项Trigger.%s无效：%s", name, err)
+		}
+	}
 	return &trigger{
-		mqttRef:    c.mqttClient,
-		globals:    c.globals,
-		nodeId:     c.nodeId,
-		opts:       opts,
-		eventIdRef: c.eventId,
+		transport:      c.transport,
+		offline:        c.offline,
+		retainedTopics: c.retainedTopics,
+		announcers:     c.announcers,
+		clock:          c.clock,
+		globals:        c.globals,
+		nodeId:         c.nodeId,
+		opts:           opts,
+		eventIdRef:     c.eventId,
 	}
 }
 
 func (c *NodeContext) NewEndpoint(opts EndpointOptions) Endpoint {
 	c.checkInit()
 	return &endpoint{
-		mqttRef:    c.mqttClient,
+		transport:      c.transport,
+		offline:        c.offline,
+		retainedTopics: c.retainedTopics,
+		announcers:     c.announcers,
+		clock:          c.clock,
+		globals:        c.globals,
+		nodeId:         c.nodeId,
+		opts:           opts,
+		eventIdRef:     c.eventId,
+	}
+}
+
+func (c *NodeContext) NewDriver(opts DriverOptions) Driver {
+	c.checkInit()
+	return &driver{
+		transport:  c.transport,
 		globals:    c.globals,
 		nodeId:     c.nodeId,
 		opts:       opts,
@@ -273,6 +571,97 @@ func (c *NodeContext) NewEndpoint(opts EndpointOptions) Endpoint {
 	}
 }
 
+func (c *NodeContext) SubscribeEvents(topicFilter string, handler MessageHandler) error {
+	return c.subscribe(TopicOfEvents(topicFilter), handler)
+}
+
+func (c *NodeContext) SubscribeValues(topicFilter string, handler MessageHandler) error {
+	return c.subscribe(TopicOfValues(topicFilter), handler)
+}
+
+func (c *NodeContext) SubscribeActions(nodeIdFilter string, handler MessageHandler) error {
+	return c.subscribe(TopicOfActions(nodeIdFilter), handler)
+}
+
+func (c *NodeContext) SubscribeStates(nodeIdFilter string, handler MessageHandler) error {
+	topic := TopicOfStates(nodeIdFilter)
+	if !strings.HasSuffix(topic, "#") {
+		// 同时订阅主节点状态及各虚拟节点状态
+		topic += "/#"
+	}
+	return c.subscribe(topic, handler)
+}
+
+func (c *NodeContext) SubscribeProperties(nodeIdFilter string, handler MessageHandler) error {
+	return c.subscribe(TopicOfProperties(nodeIdFilter), handler)
+}
+
+func (c *NodeContext) subscribe(mqttTopic string, handler MessageHandler) error {
+	c.checkInit()
+	log.Debugf("订阅Topic= %s", mqttTopic)
+	err := c.transport.Subscribe(mqttTopic, c.globals.MqttQoS, func(topic string, payload []byte) {
+		if 0 == len(payload) {
+			// 清除保留消息的空消息
+			return
+		}
+		if input, err := ParseMessageE(payload); nil != err {
+			log.Errorf("接收到格式错误的消息，Topic：%s, 错误：%s", topic, err)
+		} else {
+			handler(input)
+		}
+	})
+	if nil != err {
+		return err
+	}
+	c.subTopics.Store(mqttTopic, struct{}{})
+	return nil
+}
+
+func (c *NodeContext) ConnectedBroker() string {
+	c.checkInit()
+	return c.transport.CurrentBroker()
+}
+
+func (c *NodeContext) RequestDiscovery(nodeId string) error {
+	c.checkInit()
+	return c.transport.Publish(context.Background(), TopicOfDiscovery(nodeId), c.globals.MqttQoS, false, []byte{})
+}
+
+// requestAnnounce 异步上报Properties。上报期间接收到的请求合并为一次，在本次上报完成后执行。
+func (c *NodeContext) requestAnnounce() {
+	atomic.StoreInt32(&c.announcePending, 1)
+	if !atomic.CompareAndSwapInt32(&c.announceRunning, 0, 1) {
+		return
+	}
+	go func() {
+		for {
+			for atomic.CompareAndSwapInt32(&c.announcePending, 1, 0) {
+				c.announce()
+			}
+			atomic.StoreInt32(&c.announceRunning, 0)
+			// 释放运行标记前后到达的请求，由本协程或新请求的协程之一继续处理
+			if 0 == atomic.LoadInt32(&c.announcePending) || !atomic.CompareAndSwapInt32(&c.announceRunning, 0, 1) {
+				return
+			}
+		}
+	}()
+}
+
+// announce 调用各组件注册的Properties上报函数
+func (c *NodeContext) announce() {
+	c.announcers.Range(func(_, announce interface{}) bool {
+		announce.(func())()
+		return true
+	})
+}
+
+func (c *NodeContext) OfflineStats() OfflineQueueStats {
+	if nil == c.offline {
+		return OfflineQueueStats{}
+	}
+	return c.offline.Stats()
+}
+
 func (c *NodeContext) TermChan() <-chan os.Signal {
 	return c.signals
 }
@@ -306,15 +695,21 @@ func (c *NodeContext) RemoveAttr(key string) {
 }
 
 func (c *NodeContext) checkInit() {
-	if nil == c.mqttClient {
+	if nil == c.transport {
 		log.Panic("Context未初始化，须调用Initial()/InitialWithConfig()函数")
 	}
 }
 
-func newContext(globals *Globals) Context {
-	return &NodeContext{
-		globals: globals,
+func newContext(globals *Globals, opts ...ContextOption) Context {
+	ctx := &NodeContext{
+		globals:          globals,
+		transportFactory: NewMqttTransport,
+		clock:            SystemClock(),
+	}
+	for _, opt := range opts {
+		opt(ctx)
 	}
+	return ctx
 }
 
 ////
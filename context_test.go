@@ -0,0 +1,130 @@
+package edgex
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+// unreachableTransport 模拟无法连接的Broker
+type unreachableTransport struct {
+	Transport
+	attempts int
+}
+
+func (u *unreachableTransport) SetWill(topic string, payload []byte, qos uint8, retained bool) {}
+
+func (u *unreachableTransport) OnConnected(handler func()) {}
+
+func (u *unreachableTransport) Connect() error {
+	u.attempts++
+	return errors.New("connection refused")
+}
+
+func (u *unreachableTransport) Disconnect(quiesceMillSec uint) {}
+
+func TestInitialE(t *testing.T) {
+	unreachable := new(unreachableTransport)
+	factory := WithTransport(func(clientId string, globals *Globals) Transport {
+		return unreachable
+	})
+	config := map[string]interface{}{"NodeId": "NODE"}
+
+	// Max retry
+	ctx := CreateContext(&Globals{MqttMaxRetry: 1}, factory)
+	if err := ctx.InitialE(context.Background(), config); nil == err || 1 != unreachable.attempts {
+		t.Error("Initial should fail after max retry, was: ", err, unreachable.attempts)
+	}
+
+	// No retry
+	unreachable.attempts = 0
+	ctx = CreateContext(&Globals{MqttMaxRetry: 0}, factory)
+	if err := ctx.InitialE(context.Background(), config); nil == err || 0 != unreachable.attempts {
+		t.Error("Initial should fail without connecting, was: ", err, unreachable.attempts)
+	}
+
+	// Cancel
+	ctx = CreateContext(&Globals{MqttMaxRetry: MqttRetryForever, MqttMaxRetryInterval: time.Millisecond * 10}, factory)
+	cancelCtx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	if err := ctx.InitialE(cancelCtx, config); context.DeadlineExceeded != err {
+		t.Error("Initial should be cancelled, was: ", err)
+	}
+
+	// Invalid NodeId
+	ctx = CreateContext(&Globals{MqttMaxRetry: 1}, factory)
+	if err := ctx.InitialE(context.Background(), map[string]interface{}{"NodeId": "A/B"}); nil == err {
+		t.Error("Initial should fail with invalid NodeId")
+	}
+
+	// Invalid FrameVersion
+	ctx = CreateContext(&Globals{MqttMaxRetry: 1, FrameVersion: 0x03}, factory)
+	if err := ctx.InitialE(context.Background(), config); nil == err {
+		t.Error("Initial should fail with invalid FrameVersion")
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	for n := 1; n <= 64; n++ {
+		delay := retryBackoff(n, time.Second*30)
+		if delay <= 0 || delay > time.Second*30 {
+			t.Errorf("Backoff out of range, n: %d, was: %s", n, delay)
+		}
+	}
+	if delay := retryBackoff(1, time.Second*30); delay < time.Millisecond*500 || delay > time.Second {
+		t.Error("First backoff not match, was: ", delay)
+	}
+}
+
+func TestNodeStateDisabled(t *testing.T) {
+	broker := NewMemoryBroker()
+	monitor := broker.Transport("monitor", nil)
+	_ = monitor.Connect()
+	defer monitor.Disconnect(0)
+	received := make(chan string, 8)
+	_ = monitor.Subscribe("$EdgeX/#", 0, func(topic string, payload []byte) {
+		received <- topic
+	})
+
+	// 不发送状态、属性及遗嘱消息，不响应Discovery请求
+	ctx := CreateContext(&Globals{MqttMaxRetry: 1, NodeStateRetained: true, HeartbeatInterval: time.Millisecond * 10},
+		WithTransport(broker.Transport))
+	ctx.InitialWithConfig(map[string]interface{}{
+		"NodeId":  "TOOL",
+		"Globals": map[string]interface{}{"NodeStateDisabled": true},
+	})
+	trigger := ctx.NewTrigger(TriggerOptions{
+		Topic: "tool",
+		NodePropertiesFunc: func() MainNodeProperties {
+			return MainNodeProperties{NodeType: NodeTypeTrigger, VirtualNodes: []*VirtualNodeProperties{{}}}
+		},
+	})
+	trigger.Startup()
+	_ = ctx.RequestDiscovery("")
+	select {
+	case topic := <-received:
+		if TopicOfDiscovery("") != topic {
+			t.Error("Unexpected message: ", topic)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Discovery request not received")
+	}
+	if !broker.Kick(MqttClientIdHeader + ":TOOL") {
+		t.Fatal("Kick failed")
+	}
+	select {
+	case topic := <-received:
+		t.Error("Unexpected message: ", topic)
+	case <-time.After(time.Millisecond * 100):
+	}
+	if _, ok := broker.Retained(TopicOfStates("TOOL")); ok {
+		t.Error("Retained state should not exist")
+	}
+	trigger.Shutdown()
+	ctx.destroy()
+}
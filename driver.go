@@ -0,0 +1,178 @@
+package edgex
+
+import (
+	"context"
+	"errors"
+	"github.com/bwmarrin/snowflake"
+	"sync"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+const (
+	DefaultDriverCallTimeout = time.Second * 10
+)
+
+var (
+	ErrDriverNotReady      = errors.New("driver not ready")
+	ErrDuplicateCallEvent  = errors.New("duplicate rpc call event id")
+	ErrInvalidExecutorNode = errors.New("invalid executor node id")
+)
+
+// Driver 是主动向Endpoint发起AsyncRPC控制指令，并等待其返回结果的驱动节点。
+type Driver interface {
+	NeedLifecycle
+	NeedAccessNodeId
+
+	// GenerateEventId 返回消息事件ID
+	GenerateEventId() int64
+
+	// NewRequest 创建发往目标Endpoint虚拟节点的RPC请求消息，EventId自动生成，ReplyTo为当前节点ID。
+	// RPC请求固定使用版本2消息格式，以便携带TTL等Header字段，不受 Globals.FrameVersion 影响。
+	NewRequest(executorNodeId, boardId, majorId, minorId string, body []byte, opts ...MessageOption) Message
+
+	// Call 向指定Endpoint节点发起RPC调用，阻塞等待并返回与请求EventId相同的响应消息。
+	// 等待时间以ctx的Deadline和DriverOptions.CallTimeout两者中较早者为准；ctx被取消时立即返回。
+	// 请求消息未指定TTL时，以剩余等待时间作为TTL发送，Endpoint超过TTL后将取消处理；
+	// 版本1格式的请求消息按版本2格式发送。
+	// Endpoint返回错误响应时，返回 *RpcError 类型的错误。
+	// 注意：不可在同一Context的订阅处理函数中同步调用，否则将阻塞RPC响应的投递。
+	Call(ctx context.Context, executorNodeId string, req Message) (Message, error)
+}
+
+type DriverOptions struct {
+	CallTimeout time.Duration // 单次RPC调用的超时时间，为0时使用默认值 DefaultDriverCallTimeout
+}
+
+//// Driver实现
+
+type driver struct {
+	Driver
+	nodeId     string
+	opts       DriverOptions
+	globals    *Globals
+	eventIdRef *snowflake.Node
+	// Rpc
+	pendingCalls *sync.Map // EventId -> chan Message
+	// MQTT
+	transport         Transport
+	mqttSubReplyTopic string // MQTT使用的ReplyTopic
+	// Shutdown
+	stopContext context.Context
+	stopCancel  context.CancelFunc
+}
+
+func (d *driver) NodeId() string {
+	return d.nodeId
+}
+
+func (d *driver) GenerateEventId() int64 {
+	return d.eventIdRef.Generate().Int64()
+}
+
+func (d *driver) NewRequest(executorNodeId, boardId, majorId, minorId string, body []byte, opts ...MessageOption) Message {
+	return NewMessage(executorNodeId, boardId, majorId, minorId, body, d.GenerateEventId(),
+		append([]MessageOption{
+			WithFrameVersion(FrameVersionV2),
+			WithControlVar(FrameVarRequest),
+			WithReplyTo(d.nodeId),
+		}, opts...)...)
+}
+
+func (d *driver) Startup() {
+	d.stopContext, d.stopCancel = context.WithCancel(context.Background())
+	d.pendingCalls = new(sync.Map)
+	if 0 >= d.opts.CallTimeout {
+		d.opts.CallTimeout = DefaultDriverCallTimeout
+	}
+	// 监听Endpoint返回的RPC响应
+	d.mqttSubReplyTopic = topicOfRepliesListen(d.nodeId)
+	log.Debugf("订阅Reply-Topic= %s", d.mqttSubReplyTopic)
+	err := d.transport.Subscribe(d.mqttSubReplyTopic, d.globals.MqttQoS, func(topic string, payload []byte) {
+		reply, err := ParseMessageE(payload)
+		if nil != err {
+			log.Errorf("接收到格式错误的RPC响应，来源：%s, 错误：%s", topicToRepliesExecutor(topic), err)
+			return
+		}
+		eventId := reply.EventId()
+		if ch, ok := d.pendingCalls.Load(eventId); ok {
+			// 响应通道容量为1，重复响应直接丢弃
+			select {
+			case ch.(chan Message) <- reply:
+			default:
+			}
+		} else if d.globals.LogVerbose {
+			log.Debugf("接收到无对应请求的RPC响应，来源：%s, 事件号：%d",
+				topicToRepliesExecutor(topic), eventId)
+		}
+	})
+	if nil != err {
+		log.Error("订阅RPC响应Topic出错：", err)
+	}
+}
+
+func (d *driver) Call(ctx context.Context, executorNodeId string, req Message) (Message, error) {
+	d.checkReady()
+	if "" == executorNodeId {
+		return nil, ErrInvalidExecutorNode
+	}
+	eventId := req.EventId()
+	replyChan := make(chan Message, 1)
+	if _, loaded := d.pendingCalls.LoadOrStore(eventId, replyChan); loaded {
+		return nil, ErrDuplicateCallEvent
+	}
+	defer d.pendingCalls.Delete(eventId)
+
+	callCtx, cancel := context.WithTimeout(ctx, d.opts.CallTimeout)
+	defer cancel()
+
+	if d.globals.LogVerbose {
+		log.Debugf("发起RPC控制指令，目标：%s, 执行节点： %s, 事件号：%d",
+			req.UnionId(), executorNodeId, eventId)
+	}
+	// 版本1格式不编码TTL，须使用版本2格式发送
+	opts := []MessageOption{WithFrameVersion(FrameVersionV2)}
+	if deadline, ok := callCtx.Deadline(); ok && 0 == req.TTL() {
+		if ttl := time.Until(deadline); ttl <= MaxTTL {
+			opts = append(opts, WithTTL(ttl))
+		}
+	}
+	frame := copyMessage(req, opts...)
+	err := d.transport.Publish(callCtx,
+		topicOfRequestSend(executorNodeId, d.nodeId),
+		d.globals.MqttQoS, false,
+		frame.Bytes())
+	if nil != err {
+		return nil, err
+	}
+
+	select {
+	case reply := <-replyChan:
+		if FrameVarErrorReply == reply.Header().ControlVar {
+			return nil, ParseRpcError(reply.Body())
+		}
+		return reply, nil
+
+	case <-callCtx.Done():
+		return nil, callCtx.Err()
+
+	case <-d.stopContext.Done():
+		return nil, ErrDriverNotReady
+	}
+}
+
+func (d *driver) Shutdown() {
+	if err := d.transport.Unsubscribe(d.mqttSubReplyTopic); nil != err {
+		log.Error("取消订阅RPC响应Topic出错：", err)
+	}
+	d.stopCancel()
+}
+
+func (d *driver) checkReady() {
+	if d.stopCancel == nil || d.stopContext == nil {
+		log.Panic("Driver未启动，须调用Startup()/Shutdown()")
+	}
+}
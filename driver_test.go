@@ -0,0 +1,88 @@
+package edgex
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+// startResponder 模拟Endpoint节点，接收发往nodeId的RPC请求，并以respond返回的消息作为响应；respond返回nil时不响应。
+func startResponder(t *testing.T, broker *MemoryBroker, nodeId string, respond func(req Message) []Message) Transport {
+	transport := broker.Transport("RESPONDER:"+nodeId, nil)
+	if err := transport.Connect(); nil != err {
+		t.Fatal("Responder connect failed: ", err)
+	}
+	err := transport.Subscribe(prefixRequests+nodeId+"/+", 0, func(topic string, payload []byte) {
+		req := ParseMessage(payload)
+		for _, reply := range respond(req) {
+			_ = transport.Publish(context.Background(), topicOfRepliesSend(nodeId, topicToRequestCaller(topic)),
+				0, false, reply.Bytes())
+		}
+	})
+	if nil != err {
+		t.Fatal("Responder subscribe failed: ", err)
+	}
+	return transport
+}
+
+func TestDriverCall(t *testing.T) {
+	broker := NewMemoryBroker()
+	received := make(chan Message, 4)
+	responder := startResponder(t, broker, "ENDPOINT", func(req Message) []Message {
+		received <- req
+		if "slow" == req.MajorId() {
+			return nil
+		}
+		// 先返回事件号不匹配的响应，Driver须按事件号关联请求
+		return []Message{
+			NewMessageByUnionId(req.UnionId(), []byte("OTHER"), req.EventId()+1, WithControlVar(FrameVarReply)),
+			NewMessageByUnionId(req.UnionId(), []byte("REPLY"), req.EventId(), WithControlVar(FrameVarReply)),
+		}
+	})
+	defer responder.Disconnect(0)
+
+	driverCtx := newLoopbackContext(broker, "DRIVER")
+	defer driverCtx.destroy()
+	driver := driverCtx.NewDriver(DriverOptions{CallTimeout: time.Millisecond * 200})
+	driver.Startup()
+	defer driver.Shutdown()
+
+	// Correlation
+	req := driver.NewRequest("ENDPOINT", "main", "echo", "", []byte("HELLO"))
+	if FrameVarRequest != req.Header().ControlVar || "ENDPOINT:main:echo:" != req.UnionId() {
+		t.Error("Request not match, was: ", req.Header(), req.UnionId())
+	}
+	reply, err := driver.Call(context.Background(), "ENDPOINT", req)
+	if nil != err {
+		t.Fatal("Call failed: ", err)
+	}
+	if req.EventId() != reply.EventId() || "REPLY" != string(reply.Body()) {
+		t.Error("Reply not match, was: ", reply.EventId(), string(reply.Body()))
+	}
+	<-received
+
+	// Timeout
+	slow := driver.NewRequest("ENDPOINT", "main", "slow", "", nil)
+	done := make(chan error, 1)
+	go func() {
+		_, err := driver.Call(context.Background(), "ENDPOINT", slow)
+		done <- err
+	}()
+	<-received
+
+	// 同一事件号的请求未完成时，不可重复发起
+	if _, err := driver.Call(context.Background(), "ENDPOINT", slow); ErrDuplicateCallEvent != err {
+		t.Error("Duplicate call error not match, was: ", err)
+	}
+	if err := <-done; context.DeadlineExceeded != err {
+		t.Error("Timeout error not match, was: ", err)
+	}
+
+	if _, err := driver.Call(context.Background(), "", req); ErrInvalidExecutorNode != err {
+		t.Error("Invalid executor error not match, was: ", err)
+	}
+}
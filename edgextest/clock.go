@@ -0,0 +1,148 @@
+package edgextest
+
+import (
+	"github.com/nextabc-lab/edgex-go"
+	"sync"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+// FakeClock 是由测试代码控制时间推进的时钟。
+// Advance 推进时间时，逐个向到期的Ticker发送触发信号，并阻塞等待其被接收或Ticker停止，
+// 以保证定时任务按确定的顺序执行。
+type FakeClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+	changed chan struct{}
+}
+
+// NewFakeClock 创建以指定时间为起点的时钟
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{
+		now:     now,
+		changed: make(chan struct{}),
+	}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) edgex.Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ticker := &fakeTicker{
+		clock:  c,
+		period: d,
+		next:   c.now.Add(d),
+		c:      make(chan time.Time),
+		stop:   make(chan struct{}),
+	}
+	c.tickers = append(c.tickers, ticker)
+	c.notifyChanged()
+	return ticker
+}
+
+// Advance 推进时间，并触发期间到期的Ticker
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	target := c.now.Add(d)
+	c.mutex.Unlock()
+	for {
+		ticker, at := c.nextDue(target)
+		if nil == ticker {
+			break
+		}
+		select {
+		case ticker.c <- at:
+		case <-ticker.stop:
+		}
+	}
+	c.mutex.Lock()
+	c.now = target
+	c.mutex.Unlock()
+}
+
+// AwaitTickers 等待活动的Ticker数量达到count，超时返回false。
+// 用于等待组件在后台协程中创建定时任务。
+func (c *FakeClock) AwaitTickers(count int, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	for {
+		c.mutex.Lock()
+		active, changed := len(c.tickers), c.changed
+		c.mutex.Unlock()
+		if active >= count {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// nextDue 返回在target之前最早到期的Ticker，并推进其下一次到期时间
+func (c *FakeClock) nextDue(target time.Time) (*fakeTicker, time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var due *fakeTicker
+	for _, ticker := range c.tickers {
+		if ticker.next.After(target) {
+			continue
+		}
+		if nil == due || ticker.next.Before(due.next) {
+			due = ticker
+		}
+	}
+	if nil == due {
+		return nil, target
+	}
+	at := due.next
+	c.now = at
+	due.next = at.Add(due.period)
+	return due, at
+}
+
+func (c *FakeClock) remove(ticker *fakeTicker) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, t := range c.tickers {
+		if t == ticker {
+			c.tickers = append(c.tickers[:i], c.tickers[i+1:]...)
+			close(ticker.stop)
+			c.notifyChanged()
+			return
+		}
+	}
+}
+
+func (c *FakeClock) notifyChanged() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+type fakeTicker struct {
+	clock  *FakeClock
+	period time.Duration
+	next   time.Time
+	c      chan time.Time
+	stop   chan struct{}
+}
+
+func (t *fakeTicker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTicker) Stop() {
+	t.clock.remove(t)
+}
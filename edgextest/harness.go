@@ -0,0 +1,168 @@
+// Package edgextest 提供测试EdgeX节点的工具。
+//
+// Harness 基于进程内的Loopback Broker创建已初始化的Context，记录节点发布的全部消息，
+// 可向节点的Endpoint发起RPC请求，并使用 FakeClock 控制定时任务。
+package edgextest
+
+import (
+	"context"
+	"github.com/nextabc-lab/edgex-go"
+	"sync"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+const (
+	CallerNodeId   = "EDGEXTEST-CALLER" // 发起RPC请求的测试节点ID
+	DefaultTimeout = time.Second * 3    // 等待消息的默认超时时间
+)
+
+// Harness 测试EdgeX节点的工具对象
+type Harness struct {
+	// Context 被测节点的Context，已完成初始化
+	Context edgex.Context
+	// Broker 节点连接的Loopback Broker
+	Broker *edgex.MemoryBroker
+	// Clock 节点使用的时钟
+	Clock *FakeClock
+
+	t          testing.TB
+	nodeId     string
+	caller     edgex.Context
+	driver     edgex.Driver
+	recorder   edgex.Transport
+	transports []edgex.Transport
+	contexts   []edgex.Context
+	mutex      sync.Mutex
+	published  map[string][]edgex.Message
+	changed    chan struct{}
+}
+
+// New 创建被测节点的Harness对象。测试结束时须调用Close释放资源。
+func New(t testing.TB, nodeId string) *Harness {
+	h := &Harness{
+		Broker:    edgex.NewMemoryBroker(),
+		Clock:     NewFakeClock(time.Unix(0, 0)),
+		t:         t,
+		nodeId:    nodeId,
+		published: make(map[string][]edgex.Message),
+		changed:   make(chan struct{}),
+	}
+	// 记录全部EdgeX消息
+	h.recorder = h.Broker.Transport("edgextest-recorder", nil)
+	if err := h.recorder.Connect(); nil != err {
+		t.Fatal("连接Recorder出错: ", err)
+	}
+	if err := h.recorder.Subscribe("$EdgeX/#", 0, h.record); nil != err {
+		t.Fatal("订阅Recorder出错: ", err)
+	}
+	h.Context = h.NewContext(nodeId, nil)
+	h.caller = h.NewContext(CallerNodeId, nil)
+	h.driver = h.caller.NewDriver(edgex.DriverOptions{CallTimeout: DefaultTimeout})
+	h.driver.Startup()
+	return h
+}
+
+// Close 停止测试节点，按创建的相反顺序释放Harness创建的全部Context，并断开全部连接
+func (h *Harness) Close() {
+	h.driver.Shutdown()
+	for i := len(h.contexts) - 1; i >= 0; i-- {
+		edgex.DestroyContext(h.contexts[i])
+	}
+	for _, transport := range h.transports {
+		transport.Disconnect(0)
+	}
+	h.recorder.Disconnect(0)
+}
+
+// Published 返回指定Topic已发布的全部消息
+func (h *Harness) Published(topic string) []edgex.Message {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]edgex.Message{}, h.published[topic]...)
+}
+
+// AwaitPublished 等待指定Topic发布的消息数量达到count，并返回全部消息；超时时测试失败。
+func (h *Harness) AwaitPublished(topic string, count int) []edgex.Message {
+	h.t.Helper()
+	deadline := time.After(DefaultTimeout)
+	for {
+		h.mutex.Lock()
+		messages, changed := h.published[topic], h.changed
+		h.mutex.Unlock()
+		if len(messages) >= count {
+			return append([]edgex.Message{}, messages...)
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			h.t.Fatalf("等待Topic(%s)消息超时，期望数量：%d，实际数量：%d", topic, count, len(messages))
+			return nil
+		}
+	}
+}
+
+// Reset 清除已记录的消息
+func (h *Harness) Reset() {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.published = make(map[string][]edgex.Message)
+}
+
+// Call 向被测节点的Endpoint发起RPC请求，返回响应消息。Endpoint返回错误响应时，返回 *edgex.RpcError 错误。
+func (h *Harness) Call(boardId, majorId, minorId string, body []byte, opts ...edgex.MessageOption) (edgex.Message, error) {
+	req := h.driver.NewRequest(h.nodeId, boardId, majorId, minorId, body, opts...)
+	return h.driver.Call(context.Background(), h.nodeId, req)
+}
+
+// MustCall 向被测节点的Endpoint发起RPC请求，返回响应消息；请求出错时测试失败。
+func (h *Harness) MustCall(boardId, majorId, minorId string, body []byte, opts ...edgex.MessageOption) edgex.Message {
+	h.t.Helper()
+	reply, err := h.Call(boardId, majorId, minorId, body, opts...)
+	if nil != err {
+		h.t.Fatal("RPC请求出错: ", err)
+	}
+	return reply
+}
+
+// NewContext 创建连接到Loopback Broker、使用Harness时钟的Context，并完成初始化；Close时释放。
+// globals为nil时使用默认配置；MqttMaxRetry为0时使用1。
+func (h *Harness) NewContext(nodeId string, globals *edgex.Globals) edgex.Context {
+	scoped := edgex.Globals{}
+	if nil != globals {
+		scoped = *globals
+	}
+	if 0 == scoped.MqttMaxRetry {
+		scoped.MqttMaxRetry = 1
+	}
+	ctx := edgex.CreateContext(&scoped,
+		edgex.WithTransport(h.transport),
+		edgex.WithClock(h.Clock))
+	ctx.Initial(nodeId)
+	h.contexts = append(h.contexts, ctx)
+	return ctx
+}
+
+// transport 创建Loopback Transport，并记录以便Close时断开
+func (h *Harness) transport(clientId string, globals *edgex.Globals) edgex.Transport {
+	transport := h.Broker.Transport(clientId, globals)
+	h.transports = append(h.transports, transport)
+	return transport
+}
+
+func (h *Harness) record(topic string, payload []byte) {
+	msg, err := edgex.ParseMessageE(payload)
+	if nil != err {
+		// 忽略非消息帧格式的数据
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.published[topic] = append(h.published[topic], msg)
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
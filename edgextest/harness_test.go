@@ -0,0 +1,98 @@
+package edgextest
+
+import (
+	"context"
+	"errors"
+	"github.com/nextabc-lab/edgex-go"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func TestHarnessTrigger(t *testing.T) {
+	h := New(t, "TRIGGER")
+	defer h.Close()
+
+	trigger := h.Context.NewTrigger(edgex.TriggerOptions{
+		Topic: "test/trigger",
+		NodePropertiesFunc: func() edgex.MainNodeProperties {
+			return edgex.MainNodeProperties{
+				NodeType:     edgex.NodeTypeTrigger,
+				VirtualNodes: []*edgex.VirtualNodeProperties{{BoardId: "main", MajorId: "main"}},
+			}
+		},
+	})
+	trigger.Startup()
+	defer trigger.Shutdown()
+
+	if err := trigger.PublishValue("main", "main", "", []byte("42"), 1); nil != err {
+		t.Fatal("Publish failed: ", err)
+	}
+	values := h.AwaitPublished(edgex.TopicOfValues("test/trigger"), 1)
+	if "42" != string(values[0].Body()) {
+		t.Error("Value not match, was: ", string(values[0].Body()))
+	}
+
+	// Properties scheduler
+	if !h.Clock.AwaitTickers(1, DefaultTimeout) {
+		t.Fatal("Properties scheduler not started")
+	}
+	h.Clock.Advance(time.Second * 10)
+	h.AwaitPublished(edgex.TopicOfProperties("TRIGGER"), 1)
+	h.Clock.Advance(time.Second * 40)
+	h.AwaitPublished(edgex.TopicOfProperties("TRIGGER"), 5)
+	if !h.Clock.AwaitTickers(0, DefaultTimeout) {
+		t.Error("Properties scheduler not stopped")
+	}
+}
+
+func TestHarnessEndpoint(t *testing.T) {
+	h := New(t, "ENDPOINT")
+	defer h.Close()
+
+	endpoint := h.Context.NewEndpoint(edgex.EndpointOptions{})
+	endpoint.ServeE(func(ctx context.Context, request edgex.Message) ([]byte, error) {
+		if "fail" == request.MajorId() {
+			return nil, errors.New("failure")
+		}
+		return request.Body(), nil
+	})
+	endpoint.Startup()
+	defer endpoint.Shutdown()
+
+	if reply := h.MustCall("main", "echo", "", []byte("PING")); "PING" != string(reply.Body()) {
+		t.Error("Reply not match, was: ", string(reply.Body()))
+	}
+	if _, err := h.Call("main", "fail", "", nil); nil == err {
+		t.Error("Error reply excepted")
+	}
+}
+
+func TestHarnessClose(t *testing.T) {
+	h := New(t, "NODE")
+	observer := h.Broker.Transport("observer", nil)
+	_ = observer.Connect()
+	defer observer.Disconnect(0)
+	states := make(chan edgex.MainNodeState, 4)
+	_ = observer.Subscribe(edgex.TopicOfStates("NODE"), 0, func(topic string, payload []byte) {
+		if state, err := edgex.ParseMainNodeState(edgex.ParseMessage(payload)); nil == err {
+			states <- state
+		}
+	})
+
+	// Close释放被测节点的Context，发送OFFLINE状态
+	h.Close()
+	for {
+		select {
+		case state := <-states:
+			if edgex.NodeStateOffline == state.State {
+				return
+			}
+		case <-time.After(DefaultTimeout):
+			t.Fatal("Context not destroyed on Close")
+		}
+	}
+}
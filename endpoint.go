@@ -2,8 +2,10 @@ package edgex
 
 import (
 	"context"
+	"fmt"
 	"github.com/bwmarrin/snowflake"
-	"github.com/eclipse/paho.mqtt.golang"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,6 +16,10 @@ import (
 // 指令处理函数，返回两个结果：1. 处理结果；2. 动作消息
 type EndpointServeHandler func(request Message) (response []byte)
 
+// 可返回错误的指令处理函数。返回的错误将编码为 RpcError 错误响应返回给调用方；
+// ctx在Endpoint关闭，或超过调用方指定的消息有效时长(TTL)时被取消。
+type EndpointServeHandlerE func(ctx context.Context, request Message) (response []byte, err error)
+
 // Endpoint是接收、处理，并返回结果的可控制终端节点。
 type Endpoint interface {
 	NeedLifecycle
@@ -22,20 +28,47 @@ type Endpoint interface {
 	NeedProperties
 
 	// 发送MQTT消息，使用原生MQTT Topic来发送
+	// 启用离线队列(Globals.OfflineQueueDir)时，连接断开期间的消息写入队列，重新连接后按顺序重发。
+	// 发送超时时间为 Globals.MqttPublishTimeout。
 	PublishMqtt(mqttTopic string, message Message, qos uint8, retained bool) error
 
+	// PublishMqttCtx 发送MQTT消息，ctx被取消或超时时返回ctx的错误
+	PublishMqttCtx(ctx context.Context, mqttTopic string, message Message, qos uint8, retained bool) error
+
 	// PublishAction 发送虚拟节点的Action发送消息的QoS使用默认设置。
 	PublishAction(boardId, majorId, minorId string, data []byte, eventId int64) error
 
+	// PublishActionCtx 发送虚拟节点的Action消息，ctx被取消或超时时返回ctx的错误
+	PublishActionCtx(ctx context.Context, boardId, majorId, minorId string, data []byte, eventId int64) error
+
 	// PublishActionMessage 发送虚拟节点的Action发送消息的QoS使用默认设置。
 	PublishActionMessage(message Message) error
 
+	// PublishActionMessageCtx 发送虚拟节点的Action消息，ctx被取消或超时时返回ctx的错误
+	PublishActionMessageCtx(ctx context.Context, message Message) error
+
 	// 处理RPC消息，返回处理结果及Action
 	Serve(handler EndpointServeHandler)
+
+	// 处理RPC消息，返回处理结果或错误
+	ServeE(handler EndpointServeHandlerE)
+
+	// Handle 设置处理指定虚拟节点RPC消息的处理函数。各ID可使用通配符 AnyId 匹配任意值。
+	// 设置路由后，未匹配的RPC消息由Serve/ServeE设置的处理函数处理；两者均未设置时返回节点不存在的错误响应。
+	Handle(boardId, majorId, minorId string, handler EndpointServeHandlerE)
+
+	// HandleCommand 设置处理指定命令的处理函数。消息体为 CommandRequest 的RPC消息，
+	// 其命令须在目标虚拟节点属性的StateCommands中声明，否则返回未知命令的错误响应。
+	// 命令按最近一次上报的节点属性校验；尚未上报时调用一次 EndpointOptions.NodePropertiesFunc 生成。
+	HandleCommand(name string, handler CommandHandler)
 }
 
 type EndpointOptions struct {
 	NodePropertiesFunc func() MainNodeProperties // // Inspect消息生成函数
+	RpcWorkers         int                       // RPC处理协程数量，为0时为1，即串行处理全部RPC请求
+	RpcQueueSize       int                       // RPC请求等待队列长度，为0时使用默认值 DefaultRpcQueueSize
+	RpcOverflowPolicy  OverflowPolicy            // RPC请求等待队列已满时的处理策略，默认为阻塞等待
+	RpcSerialByNode    bool                      // 同一虚拟节点(UnionId)的RPC请求是否串行处理
 }
 
 //// Endpoint实现
@@ -47,9 +80,19 @@ type endpoint struct {
 	globals    *Globals
 	eventIdRef *snowflake.Node
 	// Rpc
-	rpcServeHandler EndpointServeHandler
+	rpcServeHandler EndpointServeHandlerE
+	rpcRouter       rpcRouter
+	rpcCommands     rpcCommands
+	properties      atomic.Value // *MainNodeProperties，最近上报的节点属性，用于校验命令请求
+	rpcQueues       []chan *rpcTask
+	rpcMutex        sync.RWMutex
+	rpcStopped      bool
 	// MQTT
-	mqttRef            mqtt.Client
+	transport          Transport
+	offline            *offlineQueue // 离线消息队列，未启用时为nil
+	retainedTopics     *sync.Map     // 已发送的节点状态及属性保留消息Topic
+	announcers         *sync.Map     // Context中注册的Properties上报函数
+	clock              Clock
 	mqttPubActionTopic string // MQTT使用的ActionTopic
 	mqttSubRpcTopic    string // MQTT使用的RpcTopic
 	// Shutdown
@@ -65,94 +108,191 @@ func (e *endpoint) GenerateEventId() int64 {
 	return e.eventIdRef.Generate().Int64()
 }
 
-func (e *endpoint) NewMessage(boardId, majorId, minorId string, body []byte, eventId int64) Message {
-	return NewMessage(e.nodeId, boardId, majorId, minorId, body, eventId)
+func (e *endpoint) NewMessage(boardId, majorId, minorId string, body []byte, eventId int64, opts ...MessageOption) Message {
+	return NewMessage(e.nodeId, boardId, majorId, minorId, body, eventId,
+		append([]MessageOption{WithFrameVersion(e.globals.FrameVersion), WithReplyTo(e.nodeId)}, opts...)...)
 }
 
 func (e *endpoint) PublishAction(boardId, majorId, minorId string, data []byte, eventId int64) error {
-	return e.PublishActionMessage(e.NewMessage(boardId, majorId, minorId, data, eventId))
+	return e.PublishActionCtx(context.Background(), boardId, majorId, minorId, data, eventId)
+}
+
+func (e *endpoint) PublishActionCtx(ctx context.Context, boardId, majorId, minorId string, data []byte, eventId int64) error {
+	return e.PublishActionMessageCtx(ctx, e.NewMessage(boardId, majorId, minorId, data, eventId))
 }
 
 func (e *endpoint) PublishActionMessage(message Message) error {
-	return e.PublishMqtt(
+	return e.PublishActionMessageCtx(context.Background(), message)
+}
+
+func (e *endpoint) PublishActionMessageCtx(ctx context.Context, message Message) error {
+	return e.PublishMqttCtx(ctx,
 		e.mqttPubActionTopic,
 		message,
 		e.globals.MqttQoS, e.globals.MqttRetained)
 }
 
 func (e *endpoint) PublishMqtt(mqttTopic string, message Message, qos uint8, retained bool) error {
+	return e.PublishMqttCtx(context.Background(), mqttTopic, message, qos, retained)
+}
+
+func (e *endpoint) PublishMqttCtx(ctx context.Context, mqttTopic string, message Message, qos uint8, retained bool) error {
 	e.checkReady()
-	token := e.mqttRef.Publish(
+	if nil != e.offline {
+		return e.offline.publish(ctx, e.transport, mqttTopic, qos, retained, message.Bytes())
+	}
+	return e.transport.Publish(ctx,
 		mqttTopic,
 		qos,
 		retained,
 		message.Bytes())
-	if token.Wait() && nil != token.Error() {
-		return token.Error()
-	} else {
-		return nil
-	}
 }
 
 func (e *endpoint) Startup() {
 	e.stopContext, e.stopCancel = context.WithCancel(context.Background())
 	// 监听Endpoint异步RPC事件
-	qos := e.globals.MqttQoS
 	e.mqttPubActionTopic = TopicOfActions(e.nodeId) // Action使用当前节点作为子Topic
 	e.mqttSubRpcTopic = topicOfRequestListen(e.nodeId)
 
+	e.startWorkers()
 	log.Debugf("订阅RPC-Topic= %s", e.mqttSubRpcTopic)
-	e.mqttRef.Subscribe(e.mqttSubRpcTopic, qos, func(cli mqtt.Client, msg mqtt.Message) {
-		callerNodeId := topicToRequestCaller(msg.Topic())
-		input := ParseMessage(msg.Payload())
+	err := e.transport.Subscribe(e.mqttSubRpcTopic, e.globals.MqttQoS, func(topic string, payload []byte) {
+		callerNodeId := topicToRequestCaller(topic)
+		input, err := ParseMessageE(payload)
+		if nil != err {
+			log.Errorf("接收到格式错误的RPC控制指令，来源：%s, 错误：%s", callerNodeId, err)
+			return
+		}
 		unionId := input.UnionId()
 		eventId := input.EventId()
 		if e.globals.LogVerbose {
 			log.Debugf("接收RPC控制指令，目标：%s, 来源： %s, 事件号：%d",
 				unionId, callerNodeId, eventId)
 		}
-		output := e.rpcServeHandler(input)
-		// 确保EventId，与Input的相同
-		for i := 0; i <= 5; i++ {
-			token := e.mqttRef.Publish(
-				topicOfRepliesSend(e.nodeId, callerNodeId),
-				qos, false,
-				NewMessageByUnionId(unionId, output, eventId).Bytes())
-			if token.Wait() && nil != token.Error() {
-				log.Error("返回RPC响应出错，正在重试(500ms)：", token.Error())
-				<-time.After(500 * time.Millisecond)
-			} else {
-				break
-			}
+		switch input.Header().ControlVar {
+		case FrameVarPing:
+			e.sendReplyOnce(callerNodeId, input, FrameVarAck, nil)
+
+		case FrameVarRequest, FrameVarData:
+			e.dispatchRequest(&rpcTask{
+				callerNodeId: callerNodeId,
+				input:        input,
+				received:     time.Now(),
+			})
+
+		default:
+			log.Errorf("接收到不支持的RPC控制变量：0x%X, 来源：%s", input.Header().ControlVar, callerNodeId)
+			e.sendReplyOnce(callerNodeId, input, FrameVarErrorReply,
+				NewRpcError(RpcErrBadRequest, "unsupported control var").Bytes())
 		}
 	})
-	// 定时发送Properties消息
-	if nil != e.opts.NodePropertiesFunc {
-		prop := e.opts.NodePropertiesFunc()
-		go scheduleSendProperties(e.stopContext, func() {
-			e.PublishNodeProperties(prop)
-		})
+	if nil != err {
+		log.Error("订阅RPC-Topic出错：", err)
+	}
+	// 定时发送Properties消息，每次发送时重新生成；重新连接及接收到Discovery请求时由Context触发发送
+	if nil != e.opts.NodePropertiesFunc && !e.globals.NodeStateDisabled {
+		announce := func() {
+			e.PublishNodeProperties(e.opts.NodePropertiesFunc())
+		}
+		e.announcers.Store(e, announce)
+		go scheduleSendProperties(e.stopContext, e.globals, e.clock, announce)
 	}
 }
 
+// serveRequest 调用RPC处理函数；处理函数未找到、返回错误或Panic时返回RpcError
+func (e *endpoint) serveRequest(input Message, received time.Time) (output []byte, rpcErr *RpcError) {
+	handler, rpcErr := e.lookupHandler(input)
+	if nil != rpcErr {
+		return nil, rpcErr
+	}
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if ttl := input.TTL(); ttl > 0 {
+		ctx, cancel = context.WithDeadline(e.stopContext, received.Add(ttl))
+	} else {
+		ctx, cancel = context.WithCancel(e.stopContext)
+	}
+	defer cancel()
+	if nil != ctx.Err() {
+		return nil, toRpcError(ctx.Err())
+	}
+	defer func() {
+		if r := recover(); nil != r {
+			rpcErr = NewRpcError(RpcErrInternal, fmt.Sprintf("%v", r))
+		}
+	}()
+	output, err := handler(ctx, input)
+	if nil != err {
+		return nil, toRpcError(err)
+	}
+	return output, nil
+}
+
+// sendReply 返回RPC响应，确保EventId及帧格式版本，与Input的相同
+// sendReply 返回RPC响应，发送出错时重试
+func (e *endpoint) sendReply(callerNodeId string, input Message, controlVar byte, body []byte) {
+	reply := e.newReply(input, controlVar, body)
+	for i := 0; i <= 5; i++ {
+		err := e.transport.Publish(context.Background(),
+			topicOfRepliesSend(e.nodeId, callerNodeId),
+			e.globals.MqttQoS, false,
+			reply)
+		if nil != err {
+			log.Error("返回RPC响应出错，正在重试(500ms)：", err)
+			<-time.After(500 * time.Millisecond)
+		} else {
+			break
+		}
+	}
+}
+
+// sendReplyOnce 返回RPC响应，只发送一次。在订阅回调中使用，避免发送出错重试时阻塞后续请求。
+func (e *endpoint) sendReplyOnce(callerNodeId string, input Message, controlVar byte, body []byte) {
+	err := e.transport.Publish(context.Background(),
+		topicOfRepliesSend(e.nodeId, callerNodeId),
+		e.globals.MqttQoS, false,
+		e.newReply(input, controlVar, body))
+	if nil != err {
+		log.Error("返回RPC响应出错：", err)
+	}
+}
+
+func (e *endpoint) newReply(input Message, controlVar byte, body []byte) []byte {
+	return NewMessageByUnionId(input.UnionId(), body, input.EventId(),
+		WithFrameVersion(input.Header().Version),
+		WithControlVar(controlVar),
+		WithReplyTo(e.nodeId)).Bytes()
+}
+
 func (e *endpoint) PublishNodeProperties(properties MainNodeProperties) {
 	e.checkReady()
 	properties.NodeId = e.nodeId
-	mqttSendNodeProperties(e.globals, e.mqttRef, properties)
+	e.properties.Store(&properties)
+	mqttSendNodeProperties(e.globals, e.transport, e.retainedTopics, properties)
 }
 
 func (e *endpoint) PublishNodeState(state VirtualNodeState) {
 	e.checkReady()
 	state.NodeId = e.nodeId
-	mqttSendNodeState(e.mqttRef, state)
+	mqttSendNodeState(e.globals, e.transport, e.retainedTopics, state)
 }
 
 func (e *endpoint) Shutdown() {
-	e.mqttRef.Unsubscribe(e.mqttSubRpcTopic)
+	e.announcers.Delete(e)
+	if err := e.transport.Unsubscribe(e.mqttSubRpcTopic); nil != err {
+		log.Error("取消订阅RPC-Topic出错：", err)
+	}
 	e.stopCancel()
+	e.stopWorkers()
 }
 
 func (e *endpoint) Serve(h EndpointServeHandler) {
+	e.rpcServeHandler = func(ctx context.Context, request Message) ([]byte, error) {
+		return h(request), nil
+	}
+}
+
+func (e *endpoint) ServeE(h EndpointServeHandlerE) {
 	e.rpcServeHandler = h
 }
 
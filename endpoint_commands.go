@@ -0,0 +1,124 @@
+package edgex
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+// CommandRequest 命令请求的消息体结构，以JSON格式编码
+type CommandRequest struct {
+	Command string          `json:"command"`        // 命令名称，须为目标虚拟节点StateCommands中声明的命令
+	Args    json.RawMessage `json:"args,omitempty"` // 命令参数
+}
+
+// Command 经过校验的命令
+type Command struct {
+	Name string                 // 命令名称
+	Code string                 // 虚拟节点StateCommands中声明的命令指令，如 "AT+ECHO"
+	Args json.RawMessage        // 命令参数
+	Node *VirtualNodeProperties // 目标虚拟节点属性
+}
+
+// 命令处理函数
+type CommandHandler func(ctx context.Context, request Message, cmd Command) (response []byte, err error)
+
+// NewCommandRequest 创建命令请求的消息体
+func NewCommandRequest(command string, args interface{}) ([]byte, error) {
+	req := CommandRequest{Command: command}
+	if nil != args {
+		data, err := json.Marshal(args)
+		if nil != err {
+			return nil, err
+		}
+		req.Args = data
+	}
+	return json.Marshal(req)
+}
+
+// rpcCommands 按命令名称分发RPC请求
+type rpcCommands struct {
+	mutex    sync.RWMutex
+	handlers map[string]CommandHandler
+}
+
+func (c *rpcCommands) add(name string, handler CommandHandler) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if nil == c.handlers {
+		c.handlers = make(map[string]CommandHandler)
+	}
+	c.handlers[name] = handler
+}
+
+func (c *rpcCommands) isEmpty() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return 0 == len(c.handlers)
+}
+
+func (c *rpcCommands) lookup(name string) CommandHandler {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.handlers[name]
+}
+
+func (e *endpoint) HandleCommand(name string, handler CommandHandler) {
+	checkRequired(name, "Command名称是必须的参数")
+	e.rpcCommands.add(name, handler)
+}
+
+// lookupCommand 解析命令请求并返回处理函数。消息体不是命令请求时，返回的处理函数及错误均为nil。
+func (e *endpoint) lookupCommand(input Message) (EndpointServeHandlerE, *RpcError) {
+	if e.rpcCommands.isEmpty() {
+		return nil, nil
+	}
+	req := new(CommandRequest)
+	if err := json.Unmarshal(input.Body(), req); nil != err || "" == req.Command {
+		return nil, nil
+	}
+	node := e.findVirtualNode(input)
+	if nil == node {
+		return nil, NewRpcError(RpcErrNoSuchNode, "no such virtual node: "+input.UnionId())
+	}
+	code, ok := node.StateCommands[req.Command]
+	if !ok {
+		return nil, NewRpcError(RpcErrUnknownCommand, "unknown command: "+req.Command)
+	}
+	handler := e.rpcCommands.lookup(req.Command)
+	if nil == handler {
+		return nil, NewRpcError(RpcErrNotImplemented, "command handler not set: "+req.Command)
+	}
+	cmd := Command{
+		Name: req.Command,
+		Code: code,
+		Args: req.Args,
+		Node: node,
+	}
+	return func(ctx context.Context, request Message) ([]byte, error) {
+		return handler(ctx, request, cmd)
+	}, nil
+}
+
+// findVirtualNode 从最近上报的节点属性中查找请求消息的目标虚拟节点
+func (e *endpoint) findVirtualNode(input Message) *VirtualNodeProperties {
+	properties, _ := e.properties.Load().(*MainNodeProperties)
+	if nil == properties {
+		if nil == e.opts.NodePropertiesFunc {
+			return nil
+		}
+		generated := e.opts.NodePropertiesFunc()
+		properties = &generated
+		e.properties.Store(properties)
+	}
+	for _, vn := range properties.VirtualNodes {
+		if vn.BoardId == input.BoardId() && vn.MajorId == input.MajorId() && vn.MinorId == input.MinorId() {
+			return vn
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package edgex
+
+import (
+	"context"
+	"testing"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func TestEndpointLookupCommand(t *testing.T) {
+	evaluated := 0
+	e := &endpoint{opts: EndpointOptions{
+		NodePropertiesFunc: func() MainNodeProperties {
+			evaluated++
+			return MainNodeProperties{
+				VirtualNodes: []*VirtualNodeProperties{{
+					BoardId:       "main",
+					MajorId:       "door",
+					StateCommands: map[string]string{"open": "AT+OPEN", "close": "AT+CLOSE"},
+				}},
+			}
+		},
+	}}
+	e.HandleCommand("open", func(ctx context.Context, request Message, cmd Command) ([]byte, error) {
+		return []byte(cmd.Code + string(cmd.Args)), nil
+	})
+
+	request := func(majorId string, command string, args interface{}) Message {
+		body, _ := NewCommandRequest(command, args)
+		return NewMessage("NODE", "main", majorId, "", body, 0)
+	}
+	cases := []struct {
+		name     string
+		input    Message
+		code     int    // 期望的错误码，0表示无错误
+		response string // 期望的处理结果，空字符串表示不是命令请求
+	}{
+		{"command", request("door", "open", 1), 0, "AT+OPEN1"},
+		{"not command", NewMessage("NODE", "main", "door", "", []byte("RAW"), 0), 0, ""},
+		{"node not found", request("window", "open", nil), RpcErrNoSuchNode, ""},
+		{"unknown command", request("door", "lock", nil), RpcErrUnknownCommand, ""},
+		{"handler not set", request("door", "close", nil), RpcErrNotImplemented, ""},
+	}
+	for _, c := range cases {
+		handler, rpcErr := e.lookupCommand(c.input)
+		switch {
+		case 0 != c.code:
+			if nil == rpcErr || c.code != rpcErr.Code {
+				t.Errorf("%s: error not match, expected: %d, was: %v", c.name, c.code, rpcErr)
+			}
+
+		case nil != rpcErr:
+			t.Errorf("%s: unexpected error: %s", c.name, rpcErr)
+
+		case "" == c.response:
+			if nil != handler {
+				t.Errorf("%s: should not be handled as command", c.name)
+			}
+
+		default:
+			if out, _ := handler(context.Background(), c.input); c.response != string(out) {
+				t.Errorf("%s: response not match, expected: %s, was: %s", c.name, c.response, string(out))
+			}
+		}
+	}
+	// 节点属性仅生成一次
+	if 1 != evaluated {
+		t.Error("NodePropertiesFunc should be evaluated once, was: ", evaluated)
+	}
+}
@@ -0,0 +1,109 @@
+package edgex
+
+import (
+	"sync"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+const (
+	AnyId = "*" // 匹配任意BoardId/MajorId/MinorId的通配符
+)
+
+// rpcRoute 按虚拟节点ID匹配的RPC处理函数
+type rpcRoute struct {
+	boardId string
+	majorId string
+	minorId string
+	handler EndpointServeHandlerE
+}
+
+// match 返回路由与消息的匹配程度：-1表示不匹配，数值越大匹配越精确
+func (r *rpcRoute) match(msg Message) int {
+	score := 0
+	for _, pair := range [][2]string{
+		{r.boardId, msg.BoardId()},
+		{r.majorId, msg.MajorId()},
+		{r.minorId, msg.MinorId()},
+	} {
+		if AnyId == pair[0] {
+			continue
+		} else if pair[0] != pair[1] {
+			return -1
+		}
+		score++
+	}
+	return score
+}
+
+// rpcRouter 按请求消息UnionId分发RPC请求。多个路由同时匹配时，选择通配符最少的路由；
+// 匹配程度相同时，选择最先注册的路由。
+type rpcRouter struct {
+	mutex  sync.RWMutex
+	routes []*rpcRoute
+}
+
+func (r *rpcRouter) add(route *rpcRoute) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.routes = append(r.routes, route)
+}
+
+func (r *rpcRouter) isEmpty() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return 0 == len(r.routes)
+}
+
+func (r *rpcRouter) lookup(msg Message) EndpointServeHandlerE {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	var found *rpcRoute
+	best := -1
+	for _, route := range r.routes {
+		if score := route.match(msg); score > best {
+			found, best = route, score
+		}
+	}
+	if nil == found {
+		return nil
+	}
+	return found.handler
+}
+
+func (e *endpoint) Handle(boardId, majorId, minorId string, handler EndpointServeHandlerE) {
+	if AnyId != boardId {
+		checkRequiredId(boardId, "boardId")
+	}
+	if AnyId != majorId {
+		checkRequiredId(majorId, "majorId")
+	}
+	e.rpcRouter.add(&rpcRoute{
+		boardId: boardId,
+		majorId: majorId,
+		minorId: minorId,
+		handler: handler,
+	})
+}
+
+// lookupHandler 查找处理RPC请求的处理函数。查找顺序为：命令处理函数、路由处理函数、Serve/ServeE设置的处理函数。
+func (e *endpoint) lookupHandler(input Message) (EndpointServeHandlerE, *RpcError) {
+	if handler, err := e.lookupCommand(input); nil != handler || nil != err {
+		return handler, err
+	}
+	if e.rpcRouter.isEmpty() {
+		if nil == e.rpcServeHandler {
+			return nil, NewRpcError(RpcErrNotImplemented, "endpoint serve handler not set")
+		}
+		return e.rpcServeHandler, nil
+	}
+	if handler := e.rpcRouter.lookup(input); nil != handler {
+		return handler, nil
+	}
+	if nil != e.rpcServeHandler {
+		return e.rpcServeHandler, nil
+	}
+	return nil, NewRpcError(RpcErrNoSuchNode, "no such virtual node: "+input.UnionId())
+}
@@ -0,0 +1,41 @@
+package edgex
+
+import (
+	"context"
+	"testing"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func TestRpcRouterLookup(t *testing.T) {
+	named := func(name string) EndpointServeHandlerE {
+		return func(ctx context.Context, request Message) ([]byte, error) {
+			return []byte(name), nil
+		}
+	}
+	router := new(rpcRouter)
+	router.add(&rpcRoute{boardId: AnyId, majorId: AnyId, minorId: AnyId, handler: named("any")})
+	router.add(&rpcRoute{boardId: "main", majorId: AnyId, minorId: AnyId, handler: named("board")})
+	router.add(&rpcRoute{boardId: "main", majorId: "door", minorId: "1", handler: named("exact")})
+	router.add(&rpcRoute{boardId: "main", majorId: "door", minorId: "1", handler: named("duplicated")})
+
+	check := func(boardId, majorId, minorId, excepted string) {
+		handler := router.lookup(NewMessage("NODE", boardId, majorId, minorId, nil, 0))
+		if nil == handler {
+			t.Fatalf("Handler not found, except: %s", excepted)
+		}
+		if out, _ := handler(context.Background(), nil); excepted != string(out) {
+			t.Errorf("Handler not match, except: %s, was: %s", excepted, string(out))
+		}
+	}
+	check("main", "door", "1", "exact")
+	check("main", "door", "2", "board")
+	check("sub", "door", "1", "any")
+
+	empty := new(rpcRouter)
+	if nil != empty.lookup(NewMessage("NODE", "main", "door", "", nil, 0)) {
+		t.Error("Empty router should not match")
+	}
+}
@@ -0,0 +1,145 @@
+package edgex
+
+import (
+	"hash/fnv"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+const (
+	DefaultRpcQueueSize = 128
+)
+
+var (
+	errEndpointBusy        = NewRpcError(RpcErrBusy, "endpoint busy")
+	errEndpointUnavailable = NewRpcError(RpcErrUnavailable, "endpoint shutdown")
+)
+
+// 等待队列已满时的处理策略，用于Endpoint的RPC请求队列及Trigger的异步发送队列
+type OverflowPolicy int
+
+const (
+	OverflowBlock  OverflowPolicy = iota // 阻塞等待队列空闲
+	OverflowReject                       // 直接拒绝：RPC请求返回Busy错误响应，异步发送返回 ErrPublishQueueFull
+)
+
+// rpcTask 等待处理的RPC请求
+type rpcTask struct {
+	callerNodeId string
+	input        Message
+	received     time.Time
+}
+
+// startWorkers 启动RPC处理协程。
+// 同一虚拟节点串行处理时，每个协程使用独立的等待队列，并按UnionId分配请求；否则全部协程共享同一等待队列。
+func (e *endpoint) startWorkers() {
+	workers := e.opts.RpcWorkers
+	if 0 >= workers {
+		workers = 1
+	}
+	queueSize := e.opts.RpcQueueSize
+	if 0 >= queueSize {
+		queueSize = DefaultRpcQueueSize
+	}
+	e.rpcStopped = false
+	if e.opts.RpcSerialByNode {
+		e.rpcQueues = make([]chan *rpcTask, workers)
+		for i := range e.rpcQueues {
+			e.rpcQueues[i] = make(chan *rpcTask, queueSize)
+			go e.runWorker(e.rpcQueues[i])
+		}
+	} else {
+		e.rpcQueues = []chan *rpcTask{make(chan *rpcTask, queueSize)}
+		for i := 0; i < workers; i++ {
+			go e.runWorker(e.rpcQueues[0])
+		}
+	}
+	log.Debugf("RPC处理协程：%d，等待队列：%d，按节点串行：%v", workers, queueSize, e.opts.RpcSerialByNode)
+}
+
+func (e *endpoint) runWorker(queue <-chan *rpcTask) {
+	for {
+		select {
+		case task := <-queue:
+			e.handleTask(task)
+
+		case <-e.stopContext.Done():
+			return
+		}
+	}
+}
+
+// dispatchRequest 将RPC请求加入等待队列；队列已满时按OverflowPolicy处理，Endpoint已关闭时返回错误响应。
+// 错误响应在释放锁之后只发送一次，不阻塞订阅回调及Shutdown。
+func (e *endpoint) dispatchRequest(task *rpcTask) {
+	if rpcErr := e.enqueueRequest(task); nil != rpcErr {
+		e.sendReplyOnce(task.callerNodeId, task.input, FrameVarErrorReply, rpcErr.Bytes())
+	}
+}
+
+// enqueueRequest 将RPC请求加入等待队列，请求被拒绝时返回错误
+func (e *endpoint) enqueueRequest(task *rpcTask) *RpcError {
+	// 持有读锁期间，Shutdown不会开始清空等待队列
+	e.rpcMutex.RLock()
+	defer e.rpcMutex.RUnlock()
+	if e.rpcStopped {
+		return errEndpointUnavailable
+	}
+	queue := e.rpcQueues[0]
+	if e.opts.RpcSerialByNode {
+		hash := fnv.New32a()
+		_, _ = hash.Write([]byte(task.input.UnionId()))
+		queue = e.rpcQueues[hash.Sum32()%uint32(len(e.rpcQueues))]
+	}
+	if OverflowReject == e.opts.RpcOverflowPolicy {
+		select {
+		case queue <- task:
+		default:
+			log.Errorf("RPC请求等待队列已满，拒绝请求，目标：%s, 来源： %s, 事件号：%d",
+				task.input.UnionId(), task.callerNodeId, task.input.EventId())
+			return errEndpointBusy
+		}
+	} else {
+		select {
+		case queue <- task:
+		case <-e.stopContext.Done():
+			return errEndpointUnavailable
+		}
+	}
+	return nil
+}
+
+// stopWorkers 停止接收RPC请求，并对等待队列中未处理的请求返回Endpoint已关闭的错误响应。须在stopCancel之后调用。
+func (e *endpoint) stopWorkers() {
+	e.rpcMutex.Lock()
+	e.rpcStopped = true
+	e.rpcMutex.Unlock()
+	for _, queue := range e.rpcQueues {
+		for drained := false; !drained; {
+			select {
+			case task := <-queue:
+				e.replyUnavailable(task)
+			default:
+				drained = true
+			}
+		}
+	}
+}
+
+func (e *endpoint) replyUnavailable(task *rpcTask) {
+	e.sendReplyOnce(task.callerNodeId, task.input, FrameVarErrorReply, errEndpointUnavailable.Bytes())
+}
+
+func (e *endpoint) handleTask(task *rpcTask) {
+	input := task.input
+	if output, err := e.serveRequest(input, task.received); nil != err {
+		log.Errorf("处理RPC控制指令出错，目标：%s, 来源： %s, 事件号：%d, 错误：%s",
+			input.UnionId(), task.callerNodeId, input.EventId(), err)
+		e.sendReply(task.callerNodeId, input, FrameVarErrorReply, err.Bytes())
+	} else {
+		e.sendReply(task.callerNodeId, input, FrameVarReply, output)
+	}
+}
@@ -0,0 +1,177 @@
+package edgex
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+// newWorkersFixture 创建Endpoint及Driver，Endpoint的处理函数阻塞直到release关闭或ctx取消
+func newWorkersFixture(t *testing.T, opts EndpointOptions, active, peak *int32, release <-chan struct{}) (*endpoint, Driver, func()) {
+	broker := NewMemoryBroker()
+	endpointCtx := newLoopbackContext(broker, "ENDPOINT")
+	driverCtx := newLoopbackContext(broker, "DRIVER")
+	ep := endpointCtx.NewEndpoint(opts).(*endpoint)
+	ep.ServeE(func(ctx context.Context, request Message) ([]byte, error) {
+		n := atomic.AddInt32(active, 1)
+		defer atomic.AddInt32(active, -1)
+		for {
+			p := atomic.LoadInt32(peak)
+			if n <= p || atomic.CompareAndSwapInt32(peak, p, n) {
+				break
+			}
+		}
+		select {
+		case <-release:
+			return []byte("OK"), nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	})
+	ep.Startup()
+	driver := driverCtx.NewDriver(DriverOptions{CallTimeout: time.Second * 5})
+	driver.Startup()
+	return ep, driver, func() {
+		driver.Shutdown()
+		driverCtx.destroy()
+		endpointCtx.destroy()
+	}
+}
+
+// callAll 并发发起count个RPC调用，返回接收调用结果的通道
+func callAll(driver Driver, count int) <-chan error {
+	results := make(chan error, count)
+	for i := 0; i < count; i++ {
+		go func(i int) {
+			_, err := driver.Call(context.Background(), "ENDPOINT", driver.NewRequest("ENDPOINT", "main", "A", "", nil))
+			results <- err
+		}(i)
+	}
+	return results
+}
+
+func awaitCondition(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 2)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func TestEndpointWorkersBound(t *testing.T) {
+	for _, c := range []struct {
+		workers  int
+		expected int32
+	}{
+		{0, 1}, // 默认串行处理
+		{2, 2},
+	} {
+		var active, peak int32
+		release := make(chan struct{})
+		ep, driver, closer := newWorkersFixture(t, EndpointOptions{RpcWorkers: c.workers}, &active, &peak, release)
+		results := callAll(driver, 5)
+		awaitCondition(t, func() bool {
+			return c.expected == atomic.LoadInt32(&active) && 5-int(c.expected) == len(ep.rpcQueues[0])
+		})
+		close(release)
+		for i := 0; i < 5; i++ {
+			if err := <-results; nil != err {
+				t.Error("Call failed: ", err)
+			}
+		}
+		if c.expected != atomic.LoadInt32(&peak) {
+			t.Errorf("Concurrency not match, workers: %d, expected: %d, was: %d", c.workers, c.expected, peak)
+		}
+		ep.Shutdown()
+		closer()
+	}
+}
+
+func TestEndpointShutdownDrainsQueue(t *testing.T) {
+	var active, peak int32
+	ep, driver, closer := newWorkersFixture(t, EndpointOptions{RpcWorkers: 1}, &active, &peak, make(chan struct{}))
+	defer closer()
+	results := callAll(driver, 3)
+	awaitCondition(t, func() bool {
+		return 1 == atomic.LoadInt32(&active) && 2 == len(ep.rpcQueues[0])
+	})
+
+	// 处理中及等待中的请求均返回Endpoint已关闭的错误响应，调用方无须等待超时
+	start := time.Now()
+	ep.Shutdown()
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if rpcErr, ok := (<-results).(*RpcError); !ok || RpcErrUnavailable != rpcErr.Code {
+				t.Error("Reply should be unavailable error, was: ", rpcErr)
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("Callers should not wait for timeout, elapsed: ", elapsed)
+	}
+}
+
+// failingTransport 发布操作总是失败的Transport
+type failingTransport struct {
+	Transport
+	attempts int32
+}
+
+func (f *failingTransport) Publish(ctx context.Context, topic string, qos uint8, retained bool, payload []byte) error {
+	atomic.AddInt32(&f.attempts, 1)
+	return ErrTransportNotConnected
+}
+
+func TestEndpointBusyReplyOnce(t *testing.T) {
+	var active, peak int32
+	release := make(chan struct{})
+	ep, driver, closer := newWorkersFixture(t, EndpointOptions{RpcQueueSize: 1, RpcOverflowPolicy: OverflowReject},
+		&active, &peak, release)
+	defer closer()
+	// 依次发起调用：第一个请求进入处理后，第二个请求占满队列
+	results := callAll(driver, 1)
+	awaitCondition(t, func() bool {
+		return 1 == atomic.LoadInt32(&active)
+	})
+	queued := callAll(driver, 1)
+	awaitCondition(t, func() bool {
+		return 1 == len(ep.rpcQueues[0])
+	})
+
+	// 队列已满且发送失败时，Busy响应只发送一次，不阻塞订阅回调
+	failing := &failingTransport{Transport: ep.transport}
+	ep.transport = failing
+	start := time.Now()
+	ep.dispatchRequest(&rpcTask{
+		callerNodeId: "DRIVER",
+		input:        driver.NewRequest("ENDPOINT", "main", "A", "", nil),
+		received:     time.Now(),
+	})
+	if elapsed := time.Since(start); elapsed > time.Millisecond*200 {
+		t.Error("Busy reply should not retry, elapsed: ", elapsed)
+	}
+	if n := atomic.LoadInt32(&failing.attempts); 1 != n {
+		t.Error("Busy reply attempts not match, was: ", n)
+	}
+	ep.transport = failing.Transport
+	close(release)
+	for _, ch := range []<-chan error{results, queued} {
+		if err := <-ch; nil != err {
+			t.Error("Call failed: ", err)
+		}
+	}
+	ep.Shutdown()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"github.com/nextabc-lab/edgex-go"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func main() {
+	edgex.Run(func(ctx edgex.Context) error {
+		// 向系统注册节点
+		nodeId := "DEV-DRIVER"
+
+		// 从application.toml中读取Broker等Globals配置
+		config := ctx.LoadConfig()
+		config["NodeId"] = nodeId
+		ctx.InitialWithConfig(config)
+
+		opts := edgex.DriverOptions{
+			CallTimeout: time.Second * 3,
+		}
+		driver := ctx.NewDriver(opts)
+
+		driver.Startup()
+		defer driver.Shutdown()
+
+		ctx.Log().Debugf("创建Driver节点: [%s]", nodeId)
+
+		// 订阅Trigger的Event消息
+		if e := ctx.SubscribeEvents("example/#", func(msg edgex.Message) {
+			ctx.Log().Debugf("Driver接收Event消息： %s, 事件号：%d", msg.UnionId(), msg.EventId())
+		}); nil != e {
+			return e
+		}
+
+		timer := time.NewTicker(time.Second * 2)
+
+		for {
+			select {
+			case <-timer.C:
+				body, _ := edgex.NewCommandRequest("echo", time.Now().Unix())
+				req := driver.NewRequest("DEV-ENDPOINT", "main", "main", "", body,
+					edgex.WithContentType(edgex.ContentTypeJSON))
+				if rep, e := driver.Call(context.Background(), "DEV-ENDPOINT", req); nil != e {
+					ctx.Log().Error("Driver发起RPC调用失败: ", e)
+				} else {
+					ctx.Log().Debugf("Driver接收RPC响应： %s", string(rep.Body()))
+				}
+
+			case <-ctx.TermChan():
+				timer.Stop()
+				return nil
+			}
+		}
+	})
+}
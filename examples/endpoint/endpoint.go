@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/nextabc-lab/edgex-go"
 	"time"
@@ -28,6 +29,11 @@ func main() {
 			return []byte(fmt.Sprintf("TIME: %s", time.Now()))
 		})
 
+		endpoint.HandleCommand("echo", func(_ context.Context, in edgex.Message, cmd edgex.Command) ([]byte, error) {
+			ctx.Log().Debugf("Received command: %s, args: %s", cmd.Code, string(cmd.Args))
+			return cmd.Args, nil
+		})
+
 		endpoint.Startup()
 		defer endpoint.Shutdown()
 
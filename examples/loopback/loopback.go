@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/nextabc-lab/edgex-go"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+// 在单个进程内，使用Loopback Broker运行Trigger、Endpoint和Driver节点，无须外部MQTT Broker。
+func main() {
+	broker := edgex.NewMemoryBroker()
+	newContext := func(nodeId string) edgex.Context {
+		ctx := edgex.CreateDefaultContext(edgex.WithTransport(broker.Transport))
+		ctx.Initial(nodeId)
+		return ctx
+	}
+
+	// Endpoint
+	endpointCtx := newContext("LOOPBACK-ENDPOINT")
+	endpoint := endpointCtx.NewEndpoint(edgex.EndpointOptions{})
+	endpoint.Serve(func(in edgex.Message) (out []byte) {
+		return []byte(fmt.Sprintf("ECHO: %s", string(in.Body())))
+	})
+	endpoint.Startup()
+	defer endpoint.Shutdown()
+
+	// Trigger
+	triggerCtx := newContext("LOOPBACK-TRIGGER")
+	trigger := triggerCtx.NewTrigger(edgex.TriggerOptions{
+		Topic: "loopback/timer",
+	})
+	trigger.Startup()
+	defer trigger.Shutdown()
+
+	// Driver：监听Trigger事件，并向Endpoint发起RPC调用
+	driverCtx := newContext("LOOPBACK-DRIVER")
+	driver := driverCtx.NewDriver(edgex.DriverOptions{})
+	driver.Startup()
+	defer driver.Shutdown()
+
+	log := driverCtx.Log()
+	err := driverCtx.SubscribeEvents("loopback/#", func(msg edgex.Message) {
+		// 订阅处理函数与RPC响应共用消息投递协程，须在独立协程中发起RPC调用
+		go func() {
+			req := driver.NewRequest("LOOPBACK-ENDPOINT", "main", "main", "", msg.Body())
+			if rep, e := driver.Call(context.Background(), "LOOPBACK-ENDPOINT", req); nil != e {
+				log.Error("Driver发起RPC调用失败: ", e)
+			} else {
+				log.Debugf("Driver接收RPC响应： %s", string(rep.Body()))
+			}
+		}()
+	})
+	if nil != err {
+		log.Panic("订阅Event消息失败: ", err)
+	}
+
+	timer := time.NewTicker(time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case c := <-timer.C:
+			data := fmt.Sprintf("%d", c.UnixNano())
+			if e := trigger.PublishEvent("TIMER", "Major", "", []byte(data), trigger.GenerateEventId()); nil != e {
+				log.Error("Trigger发送消息失败: ", e)
+			}
+
+		case <-driverCtx.TermChan():
+			return
+		}
+	}
+}
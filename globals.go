@@ -1,6 +1,9 @@
 package edgex
 
-import "time"
+import (
+	"crypto/tls"
+	"time"
+)
 
 //
 // Author: 陈哈哈 yoojiachen@gmail.com
@@ -8,11 +11,14 @@ import "time"
 
 const (
 	MqttClientIdHeader = "EXNode"
+	// MqttRetryForever 初始化时持续重试连接Broker，直到连接成功或被取消
+	MqttRetryForever = -1
 )
 
 // 全局配置
 type Globals struct {
-	MqttBroker            string
+	MqttBroker            string   // Broker地址，多个地址使用逗号分隔
+	MqttBrokers           []string // Broker地址列表，按顺序故障切换；不为空时优先于MqttBroker
 	MqttUsername          string
 	MqttPassword          string
 	MqttQoS               uint8
@@ -23,8 +29,34 @@ type Globals struct {
 	MqttReconnectInterval time.Duration
 	MqttAutoReconnect     bool
 	MqttCleanSession      bool
-	MqttMaxRetry          int
+	MqttMaxRetry          int           // 初始化时连接Broker的最大尝试次数，为 MqttRetryForever 时不限制
+	MqttMaxRetryInterval  time.Duration // 初始化时连接Broker的最大重试间隔，重试间隔按指数增长
+	MqttPublishTimeout    time.Duration // 发布、订阅等MQTT操作的默认超时时间，为0时不限制
 	MqttQuitMillSec       uint
+	// TLS配置，用于连接 ssl:// 或 tls:// 协议的Broker
+	MqttCAFile             string // CA证书文件(PEM)，为空时使用系统CA证书
+	MqttCertFile           string // 客户端证书文件(PEM)，与MqttKeyFile同时设置时启用双向认证
+	MqttKeyFile            string // 客户端私钥文件(PEM)
+	MqttServerName         string // TLS握手使用的SNI服务器名称，为空时使用Broker地址中的主机名
+	MqttInsecureSkipVerify bool   // 是否跳过服务端证书校验，仅用于测试环境
+	// 自定义TLS配置；为nil时，Context初始化时根据以上TLS参数创建，证书文件错误时初始化立即返回错误
+	MqttTLSConfig *tls.Config
+	// 离线消息队列，连接断开期间发布的Event/Value/Action消息写入磁盘，重新连接后按顺序重发
+	OfflineQueueDir      string            // 离线队列存储目录，为空时不启用
+	OfflineQueueMaxBytes int64             // 离线队列最大字节数，为0时使用默认值 DefaultOfflineQueueMaxBytes
+	OfflineQueueMaxAge   time.Duration     // 离线消息的最长保留时间，为0时不限制
+	OfflineDropPolicy    OfflineDropPolicy // 离线队列已满时的丢弃策略
+	// 节点状态及属性消息
+	NodeStateQoS      uint8         // 节点状态及属性消息的QoS
+	NodeStateRetained bool          // 节点状态及属性消息是否为保留消息；正常退出时清除全部保留消息，仅异常断开时保留OFFLINE遗嘱消息
+	HeartbeatInterval time.Duration // 主节点状态心跳周期，为0时不发送心跳
+	NodeStateDisabled bool          // 不发送节点状态、遗嘱及属性消息，不响应Discovery请求；用于命令行工具等不作为节点出现的客户端
+	NodeVersion       string        // 节点程序版本，随心跳消息发送
+	// Properties上报：启动后按周期上报，重新连接及接收到Discovery请求时立即上报
+	PropertiesInterval time.Duration // 上报周期，为0时使用默认值 DefaultPropertiesInterval
+	PropertiesDuration time.Duration // 启动后持续上报的时长，为0时使用默认值 DefaultPropertiesDuration，小于0时持续上报
+	//
+	FrameVersion byte // 创建消息使用的帧格式版本，为0时使用默认版本
 	//
 	LogVerbose bool
 }
@@ -0,0 +1,95 @@
+package edgex
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+const (
+	DefaultHeartbeatInterval = time.Second * 10
+)
+
+var (
+	ErrNotMainNodeState = errors.New("message is not main node state")
+)
+
+// ParseMainNodeState 解析节点States主题中的主节点状态消息。虚拟节点状态消息返回 ErrNotMainNodeState 错误。
+// 监控方超过数个心跳周期未收到节点的ALIVE消息时，可判定节点已停止响应。
+func ParseMainNodeState(msg Message) (MainNodeState, error) {
+	state := MainNodeState{}
+	nodeId := msg.NodeId()
+	if nodeId != msg.BoardId() || nodeId != msg.MajorId() || "" != msg.MinorId() {
+		return state, ErrNotMainNodeState
+	}
+	err := json.Unmarshal(msg.Body(), &state)
+	return state, err
+}
+
+// createMainStateMessage 创建主节点状态消息，UnionId与主节点Properties消息相同
+func createMainStateMessage(globals *Globals, state MainNodeState) Message {
+	stateJSON, err := json.Marshal(state)
+	if nil != err {
+		log.Panic("数据序列化错误", err)
+	}
+	return NewMessage(state.NodeId, state.NodeId, state.NodeId, "", stateJSON, 0,
+		WithFrameVersion(globals.FrameVersion),
+		WithContentType(ContentTypeJSON))
+}
+
+func (c *NodeContext) ReportError(err error) {
+	if nil != err {
+		c.lastError.Store(err.Error())
+	}
+}
+
+// mainNodeState 返回当前主节点状态
+func (c *NodeContext) mainNodeState(state string) MainNodeState {
+	lastError, _ := c.lastError.Load().(string)
+	return MainNodeState{
+		NodeId:    c.nodeId,
+		State:     state,
+		Uptime:    int64(c.clock.Now().Sub(c.startTime) / time.Second),
+		Version:   c.globals.NodeVersion,
+		LastError: lastError,
+		Heartbeat: int64(c.globals.HeartbeatInterval / time.Millisecond),
+	}
+}
+
+// sendNodeState 发送主节点状态消息，保留消息设置使用 Globals.NodeStateRetained
+func (c *NodeContext) sendNodeState(transport Transport, state string) error {
+	return c.publishNodeState(transport, state, c.globals.NodeStateRetained)
+}
+
+func (c *NodeContext) publishNodeState(transport Transport, state string, retained bool) error {
+	return transport.Publish(context.Background(),
+		TopicOfStates(c.nodeId),
+		c.globals.NodeStateQoS,
+		retained,
+		createMainStateMessage(c.globals, c.mainNodeState(state)).Bytes())
+}
+
+// scheduleHeartbeat 按心跳周期发送ALIVE状态消息，直到shutdown被取消
+func (c *NodeContext) scheduleHeartbeat(shutdown context.Context, transport Transport) {
+	ticker := c.clock.NewTicker(c.globals.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C():
+			if !transport.IsConnected() {
+				continue
+			}
+			if err := c.sendNodeState(transport, NodeStateAlive); nil != err {
+				log.Error("发送心跳消息出错：", err)
+			}
+
+		case <-shutdown.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package edgex
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func TestHeartbeat(t *testing.T) {
+	broker := NewMemoryBroker()
+	monitor := newLoopbackContext(broker, "MONITOR")
+	defer monitor.destroy()
+	states := make(chan MainNodeState, 16)
+	if err := monitor.SubscribeStates("NODE", func(msg Message) {
+		if state, err := ParseMainNodeState(msg); nil == err {
+			states <- state
+		}
+	}); nil != err {
+		t.Fatal("Subscribe failed: ", err)
+	}
+
+	node := CreateContext(&Globals{
+		MqttMaxRetry:      1,
+		HeartbeatInterval: time.Millisecond * 20,
+		NodeVersion:       "1.0.0",
+	}, WithTransport(broker.Transport))
+	node.Initial("NODE")
+	node.ReportError(errors.New("sensor offline"))
+
+	await := func(expected string) MainNodeState {
+		select {
+		case state := <-states:
+			if expected != state.State || "NODE" != state.NodeId || "1.0.0" != state.Version || 20 != state.Heartbeat {
+				t.Fatal("State not match, was: ", state)
+			}
+			return state
+		case <-time.After(time.Second):
+			t.Fatal("State not received: ", expected)
+		}
+		return MainNodeState{}
+	}
+	await(NodeStateAlive)
+	if state := await(NodeStateAlive); "sensor offline" != state.LastError {
+		t.Error("LastError not match, was: ", state.LastError)
+	}
+	// 正常退出时发送OFFLINE状态
+	node.destroy()
+	for {
+		select {
+		case state := <-states:
+			if NodeStateOffline == state.State {
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatal("OFFLINE state not received")
+		}
+	}
+}
+
+func TestRetainedNodeState(t *testing.T) {
+	broker := NewMemoryBroker()
+	node := CreateContext(&Globals{
+		MqttMaxRetry:      1,
+		NodeStateQoS:      1,
+		NodeStateRetained: true,
+	}, WithTransport(broker.Transport))
+	node.Initial("NODE")
+
+	trigger := node.NewTrigger(TriggerOptions{Topic: "example/retained"})
+	trigger.Startup()
+	trigger.PublishNodeProperties(MainNodeProperties{
+		NodeType:     NodeTypeTrigger,
+		VirtualNodes: []*VirtualNodeProperties{{BoardId: "main", MajorId: "A"}},
+	})
+	trigger.PublishNodeState(VirtualNodeState{BoardId: "main", MajorId: "A", State: "ON"})
+	trigger.PublishNodeState(VirtualNodeState{BoardId: "main", MajorId: "B", State: "OFF"})
+
+	// 后启动的订阅方接收到保留消息
+	monitor := newLoopbackContext(broker, "MONITOR")
+	defer monitor.destroy()
+	states := make(chan Message, 8)
+	if err := monitor.SubscribeStates("NODE", func(msg Message) {
+		states <- msg
+	}); nil != err {
+		t.Fatal("Subscribe failed: ", err)
+	}
+	received := make(map[string]bool)
+	for len(received) < 3 {
+		select {
+		case msg := <-states:
+			received[msg.UnionId()] = true
+		case <-time.After(time.Second):
+			t.Fatal("Retained states not received, was: ", received)
+		}
+	}
+	if !received["NODE:main:A:"] || !received["NODE:main:B:"] || !received["NODE:NODE:NODE:"] {
+		t.Error("Retained states not match, was: ", received)
+	}
+	if _, ok := broker.Retained(TopicOfProperties("NODE")); !ok {
+		t.Error("Properties should be retained")
+	}
+
+	// 正常退出时清除全部保留消息，在线的订阅方接收到OFFLINE状态
+	trigger.Shutdown()
+	node.destroy()
+	for offline := false; !offline; {
+		select {
+		case msg := <-states:
+			state, err := ParseMainNodeState(msg)
+			offline = nil == err && NodeStateOffline == state.State
+		case <-time.After(time.Second):
+			t.Fatal("OFFLINE state not received")
+		}
+	}
+	if _, ok := broker.Retained(TopicOfProperties("NODE")); ok {
+		t.Error("Retained properties should be cleared")
+	}
+	if _, ok := broker.Retained(TopicOfVirtualStates("NODE:main:A:")); ok {
+		t.Error("Retained state should be cleared")
+	}
+	if _, ok := broker.Retained(TopicOfStates("NODE")); ok {
+		t.Error("Retained main node state should be cleared")
+	}
+}
@@ -20,15 +20,16 @@ type NeedCreateMessages interface {
 	// GenerateEventId 返回消息事件ID
 	GenerateEventId() int64
 
-	// NewMessage 创建基于节点的消息对象
-	NewMessage(boardId, majorId, minorId string, body []byte, eventId int64) Message
+	// NewMessage 创建基于节点的消息对象。消息的ReplyTo默认为当前节点ID。
+	NewMessage(boardId, majorId, minorId string, body []byte, eventId int64, opts ...MessageOption) Message
 }
 
 // 发布State/Properties消息
 type NeedProperties interface {
-	// 发送节点属性消息
+	// 发送节点属性消息。Globals.NodeStateRetained 为true时作为保留消息发送，正常退出时清除。
+	// 缺少NodeType或虚拟节点数据时记录错误日志，不发送消息。
 	PublishNodeProperties(properties MainNodeProperties)
 
-	// 发送节点状态消息
+	// 发送虚拟节点状态消息到 TopicOfVirtualStates 主题，保留消息设置同上。
 	PublishNodeState(state VirtualNodeState)
 }
@@ -0,0 +1,263 @@
+package edgex
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func newLoopbackContext(broker *MemoryBroker, nodeId string) Context {
+	ctx := CreateContext(&Globals{
+		MqttMaxRetry: 1,
+	}, WithTransport(broker.Transport))
+	ctx.Initial(nodeId)
+	return ctx
+}
+
+// isCallTimeout 判断RPC调用是否超时：Endpoint按TTL取消处理并返回超时错误响应，或调用方先到达等待时限
+func isCallTimeout(err error) bool {
+	if rpcErr, ok := err.(*RpcError); ok {
+		return RpcErrTimeout == rpcErr.Code
+	}
+	return context.DeadlineExceeded == err
+}
+
+func TestLoopbackTriggerToDriver(t *testing.T) {
+	broker := NewMemoryBroker()
+	triggerCtx := newLoopbackContext(broker, "TRIGGER")
+	defer triggerCtx.destroy()
+	driverCtx := newLoopbackContext(broker, "DRIVER")
+	defer driverCtx.destroy()
+
+	received := make(chan Message, 1)
+	if err := driverCtx.SubscribeEvents("example/#", func(msg Message) {
+		received <- msg
+	}); nil != err {
+		t.Fatal("Subscribe failed: ", err)
+	}
+
+	trigger := triggerCtx.NewTrigger(TriggerOptions{Topic: "example/timer"})
+	trigger.Startup()
+	defer trigger.Shutdown()
+
+	eventId := trigger.GenerateEventId()
+	if err := trigger.PublishEvent("TIMER", "Major", "", []byte("TICK"), eventId); nil != err {
+		t.Fatal("Publish failed: ", err)
+	}
+	select {
+	case msg := <-received:
+		if eventId != msg.EventId() || "TRIGGER:TIMER:Major:" != msg.UnionId() || "TICK" != string(msg.Body()) {
+			t.Error("Event not match, was: ", msg.UnionId(), msg.EventId())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Event not received")
+	}
+}
+
+func TestLoopbackEndpointRpc(t *testing.T) {
+	broker := NewMemoryBroker()
+	endpointCtx := newLoopbackContext(broker, "ENDPOINT")
+	defer endpointCtx.destroy()
+	driverCtx := newLoopbackContext(broker, "DRIVER")
+	defer driverCtx.destroy()
+
+	endpoint := endpointCtx.NewEndpoint(EndpointOptions{RpcWorkers: 2})
+	endpoint.Serve(func(request Message) []byte {
+		return append([]byte("ECHO:"), request.Body()...)
+	})
+	endpoint.Handle("main", "fail", AnyId, func(ctx context.Context, request Message) ([]byte, error) {
+		return nil, errors.New("device failure")
+	})
+	endpoint.Handle("main", "slow", AnyId, func(ctx context.Context, request Message) ([]byte, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	endpoint.Startup()
+	defer endpoint.Shutdown()
+
+	driver := driverCtx.NewDriver(DriverOptions{CallTimeout: time.Second})
+	driver.Startup()
+	defer driver.Shutdown()
+
+	// Reply
+	req := driver.NewRequest("ENDPOINT", "main", "echo", "", []byte("HELLO"))
+	reply, err := driver.Call(context.Background(), "ENDPOINT", req)
+	if nil != err {
+		t.Fatal("Call failed: ", err)
+	}
+	if req.EventId() != reply.EventId() || FrameVarReply != reply.Header().ControlVar {
+		t.Error("Reply header not match, was: ", reply.Header())
+	}
+	if !bytes.Equal([]byte("ECHO:HELLO"), reply.Body()) {
+		t.Error("Reply body not match, was: ", string(reply.Body()))
+	}
+
+	// Error reply
+	_, err = driver.Call(context.Background(), "ENDPOINT", driver.NewRequest("ENDPOINT", "main", "fail", "", nil))
+	if rpcErr, ok := err.(*RpcError); !ok || RpcErrInternal != rpcErr.Code || "device failure" != rpcErr.Message {
+		t.Error("Error reply not match, was: ", err)
+	}
+
+	// Timeout
+	callCtx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	_, err = driver.Call(callCtx, "ENDPOINT", driver.NewRequest("ENDPOINT", "main", "slow", "", nil))
+	if !isCallTimeout(err) {
+		t.Error("Timeout not match, was: ", err)
+	}
+}
+
+func TestLoopbackRpcTTL(t *testing.T) {
+	broker := NewMemoryBroker()
+	// 使用默认帧格式版本的Context
+	endpointCtx := newLoopbackContext(broker, "ENDPOINT")
+	defer endpointCtx.destroy()
+	driverCtx := newLoopbackContext(broker, "DRIVER")
+	defer driverCtx.destroy()
+
+	cancelled := make(chan error, 1)
+	endpoint := endpointCtx.NewEndpoint(EndpointOptions{})
+	endpoint.ServeE(func(ctx context.Context, request Message) ([]byte, error) {
+		<-ctx.Done()
+		cancelled <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	endpoint.Startup()
+	defer endpoint.Shutdown()
+
+	driver := driverCtx.NewDriver(DriverOptions{CallTimeout: time.Millisecond * 100})
+	driver.Startup()
+	defer driver.Shutdown()
+
+	if _, err := driver.Call(context.Background(), "ENDPOINT", driver.NewRequest("ENDPOINT", "main", "A", "", nil)); !isCallTimeout(err) {
+		t.Error("Call should timeout, was: ", err)
+	}
+	// 超过调用方的TTL后，Endpoint取消处理函数的ctx
+	select {
+	case err := <-cancelled:
+		if context.DeadlineExceeded != err {
+			t.Error("Handler ctx error not match, was: ", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Handler ctx not cancelled after TTL")
+	}
+}
+
+func TestLoopbackDiscovery(t *testing.T) {
+	broker := NewMemoryBroker()
+	nodeCtx := newLoopbackContext(broker, "NODE")
+	defer nodeCtx.destroy()
+	managerCtx := newLoopbackContext(broker, "MANAGER")
+	defer managerCtx.destroy()
+
+	received := make(chan string, 8)
+	manager := broker.Transport("MANAGER-MONITOR", nil)
+	_ = manager.Connect()
+	defer manager.Disconnect(0)
+	_ = manager.Subscribe(TopicOfProperties("NODE"), 0, func(topic string, payload []byte) {
+		received <- string(ParseMessage(payload).Body())
+	})
+
+	// 每次上报时重新生成Properties
+	vendor := "V1"
+	trigger := nodeCtx.NewTrigger(TriggerOptions{
+		Topic: "example/discovery",
+		NodePropertiesFunc: func() MainNodeProperties {
+			return MainNodeProperties{
+				NodeType:     NodeTypeTrigger,
+				Vendor:       vendor,
+				VirtualNodes: []*VirtualNodeProperties{{BoardId: "main", MajorId: "A"}},
+			}
+		},
+	})
+	trigger.Startup()
+	defer trigger.Shutdown()
+
+	await := func(vendor string) {
+		select {
+		case body := <-received:
+			if !bytes.Contains([]byte(body), []byte(`"vendor":"`+vendor+`"`)) {
+				t.Error("Properties not match, was: ", body)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Properties not received")
+		}
+	}
+	if err := managerCtx.RequestDiscovery(""); nil != err {
+		t.Fatal("Request discovery failed: ", err)
+	}
+	await("V1")
+
+	vendor = "V2"
+	if err := managerCtx.RequestDiscovery("NODE"); nil != err {
+		t.Fatal("Request discovery failed: ", err)
+	}
+	await("V2")
+
+	// 重新连接时上报
+	broker.Kick("EXNode:NODE")
+	if err := nodeCtx.(*NodeContext).transport.Connect(); nil != err {
+		t.Fatal("Reconnect failed: ", err)
+	}
+	await("V2")
+}
+
+func TestLoopbackDiscoveryCoalesce(t *testing.T) {
+	broker := NewMemoryBroker()
+	nodeCtx := newLoopbackContext(broker, "NODE")
+	defer nodeCtx.destroy()
+	managerCtx := newLoopbackContext(broker, "MANAGER")
+	defer managerCtx.destroy()
+
+	published := make(chan struct{}, 8)
+	manager := broker.Transport("MANAGER-MONITOR", nil)
+	_ = manager.Connect()
+	defer manager.Disconnect(0)
+	_ = manager.Subscribe(TopicOfProperties("NODE"), 0, func(topic string, payload []byte) {
+		published <- struct{}{}
+	})
+
+	// 第一次上报阻塞期间的Discovery请求合并为一次；缺少虚拟节点数据时不发送消息，也不中断进程
+	var calls int32
+	started, release := make(chan struct{}), make(chan struct{})
+	trigger := nodeCtx.NewTrigger(TriggerOptions{
+		Topic: "example/coalesce",
+		NodePropertiesFunc: func() MainNodeProperties {
+			if 1 == atomic.AddInt32(&calls, 1) {
+				close(started)
+				<-release
+			}
+			return MainNodeProperties{NodeType: NodeTypeTrigger}
+		},
+	})
+	trigger.Startup()
+	defer trigger.Shutdown()
+
+	if err := managerCtx.RequestDiscovery("NODE"); nil != err {
+		t.Fatal("Request discovery failed: ", err)
+	}
+	<-started
+	for i := 0; i < 5; i++ {
+		nodeCtx.(*NodeContext).requestAnnounce()
+	}
+	close(release)
+	awaitCondition(t, func() bool {
+		return 2 == atomic.LoadInt32(&calls)
+	})
+	time.Sleep(time.Millisecond * 50)
+	if n := atomic.LoadInt32(&calls); 2 != n {
+		t.Error("Discovery requests should be coalesced, announces: ", n)
+	}
+	select {
+	case <-published:
+		t.Error("Properties without virtual nodes should not be published")
+	default:
+	}
+}
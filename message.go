@@ -1,10 +1,13 @@
 package edgex
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
 	"strings"
+	"time"
 )
 
 //
@@ -12,14 +15,47 @@ import (
 //
 
 const (
-	FrameMagic   byte = 0xED // Magic
-	FrameVersion      = 0x01 // 版本
-	FrameEmpty        = 0x00 // 分隔空帧
-	FrameVarData      = 0xDA
+	FrameMagic     byte = 0xED           // Magic
+	FrameVersionV1      = 0x01           // 版本1：UnionId以空帧结束，Body无长度字段
+	FrameVersionV2      = 0x02           // 版本2：各字段带长度前缀，支持Header扩展及CRC32校验
+	FrameVersion        = FrameVersionV1 // 默认版本
+	FrameEmpty          = 0x00           // 分隔空帧
+)
+
+// MaxTTL 消息有效时长的最大值，Header以uint32毫秒编码
+const MaxTTL = time.Duration(math.MaxUint32) * time.Millisecond
+
+// ControlVar 控制变量，用于在帧层面区分消息类型
+const (
+	FrameVarData       byte = 0xDA // 普通数据消息
+	FrameVarRequest         = 0xD1 // RPC请求
+	FrameVarReply           = 0xD2 // RPC正常响应
+	FrameVarErrorReply      = 0xD3 // RPC错误响应，消息体为 RpcError 的JSON数据
+	FrameVarPing            = 0xD4 // 存活探测
+	FrameVarAck             = 0xD5 // 确认响应
 )
 
 const (
 	eventIdByteSize = 8
+	frameHeaderSize = 3 /*Magic+Ver+Var*/ + eventIdByteSize
+)
+
+var (
+	ErrFrameTruncatedHeader    = errors.New("frame header truncated")
+	ErrFrameBadMagic           = errors.New("frame magic not match")
+	ErrFrameUnsupportedVersion = errors.New("frame version not supported")
+	ErrFrameMissingUnionIdEnd  = errors.New("frame union id terminator not found")
+	ErrFrameTruncated          = errors.New("frame data truncated")
+	ErrFrameChecksum           = errors.New("frame checksum not match")
+)
+
+// 消息体内容类型
+const (
+	ContentTypeUnknown  byte = 0x00 // 未指定
+	ContentTypeRaw           = 0x01 // 原始字节
+	ContentTypeText          = 0x02 // UTF-8文本
+	ContentTypeJSON          = 0x03 // JSON
+	ContentTypeProtobuf      = 0x04 // Protobuf
 )
 
 // Header 头部
@@ -28,6 +64,89 @@ type Header struct {
 	Version    byte  // 协议版本
 	ControlVar byte  // 控制变量
 	EventId    int64 // 消息事件ID，具有唯一性
+	// 以下为可选字段，仅版本2及以上的消息格式支持：版本1的消息编码时丢弃这些字段，解析后为零值。
+	Timestamp   int64             // 消息创建时间，Unix毫秒
+	ContentType byte              // 消息体内容类型
+	TTL         uint32            // 消息有效时长，毫秒；为0时不限制
+	ReplyTo     string            // 回复/关联节点ID
+	Extensions  []HeaderExtension // 自定义Header扩展字段
+}
+
+// HeaderExtension Header扩展字段，以 Tag-Length-Value 格式编码。
+// Tag 0x00 ~ 0x7F 为系统保留，自定义扩展字段须使用 0x80 ~ 0xFF（HeaderExtensionUserMin 及以上）。
+type HeaderExtension struct {
+	Tag   byte
+	Value []byte
+}
+
+// 自定义Header扩展字段的最小Tag值
+const HeaderExtensionUserMin byte = 0x80
+
+// 消息创建选项
+type MessageOption func(header *Header)
+
+// WithFrameVersion 指定消息的帧格式版本，取值为 FrameVersionV1 或 FrameVersionV2。版本为0时使用默认版本。
+func WithFrameVersion(version byte) MessageOption {
+	if err := verifyFrameVersion(version); nil != err {
+		log.Panic(err)
+	}
+	return func(header *Header) {
+		if 0 != version {
+			header.Version = version
+		}
+	}
+}
+
+// WithControlVar 指定消息的控制变量，取值为 FrameVar* 常量。
+func WithControlVar(controlVar byte) MessageOption {
+	return func(header *Header) {
+		header.ControlVar = controlVar
+	}
+}
+
+// WithTimestamp 指定消息创建时间。默认为创建消息对象的时间。仅版本2消息格式编码此字段。
+func WithTimestamp(t time.Time) MessageOption {
+	return func(header *Header) {
+		header.Timestamp = t.UnixNano() / int64(time.Millisecond)
+	}
+}
+
+// WithContentType 指定消息体内容类型，取值为 ContentType* 常量。仅版本2消息格式编码此字段。
+func WithContentType(contentType byte) MessageOption {
+	return func(header *Header) {
+		header.ContentType = contentType
+	}
+}
+
+// WithTTL 指定消息有效时长，精度为毫秒，最大为 MaxTTL。仅版本2消息格式编码此字段。
+func WithTTL(ttl time.Duration) MessageOption {
+	if ttl > MaxTTL {
+		log.Panicf("消息有效时长超出限制：%s，最大：%s", ttl, MaxTTL)
+	}
+	return func(header *Header) {
+		if ttl > 0 {
+			header.TTL = uint32(ttl / time.Millisecond)
+		} else {
+			header.TTL = 0
+		}
+	}
+}
+
+// WithReplyTo 指定回复/关联节点ID。仅版本2消息格式编码此字段。
+func WithReplyTo(nodeId string) MessageOption {
+	return func(header *Header) {
+		header.ReplyTo = nodeId
+	}
+}
+
+// WithHeaderExtension 添加Header扩展字段，Tag须不小于 HeaderExtensionUserMin。版本1的消息格式不编码扩展字段。
+func WithHeaderExtension(tag byte, value []byte) MessageOption {
+	if tag < HeaderExtensionUserMin {
+		log.Panicf("Header扩展字段Tag 0x%02X 为系统保留，自定义扩展字段须使用 0x%02X ~ 0xFF", tag, HeaderExtensionUserMin)
+	}
+	return func(header *Header) {
+		header.Extensions = append(header.Extensions, HeaderExtension{Tag: tag, Value: value})
+	}
 }
 
 // Message 消息接口。
@@ -54,6 +173,18 @@ type Message interface {
 	// 消息ID使用SnowflakeID生成器具有唯一性。
 	EventId() int64
 
+	// Timestamp 返回消息创建时间。消息未携带时间时返回零值。
+	Timestamp() time.Time
+
+	// ContentType 返回消息体内容类型
+	ContentType() byte
+
+	// TTL 返回消息有效时长；为0时不限制。
+	TTL() time.Duration
+
+	// ReplyTo 返回回复/关联节点ID
+	ReplyTo() string
+
 	// Body 返回消息体字节
 	Body() []byte
 
@@ -99,11 +230,33 @@ func (m *message) EventId() int64 {
 	return m.header.EventId
 }
 
+func (m *message) Timestamp() time.Time {
+	if 0 == m.header.Timestamp {
+		return time.Time{}
+	}
+	return time.Unix(0, m.header.Timestamp*int64(time.Millisecond))
+}
+
+func (m *message) ContentType() byte {
+	return m.header.ContentType
+}
+
+func (m *message) TTL() time.Duration {
+	return time.Duration(m.header.TTL) * time.Millisecond
+}
+
+func (m *message) ReplyTo() string {
+	return m.header.ReplyTo
+}
+
 func (m *message) Body() []byte {
 	return m.body
 }
 
 func (m *message) Bytes() []byte {
+	if FrameVersionV2 == m.header.Version {
+		return encodeMessageV2(m)
+	}
 	buf := new(bytes.Buffer)
 	buf.WriteByte(m.header.Magic)
 	buf.WriteByte(m.header.Version)
@@ -115,6 +268,21 @@ func (m *message) Bytes() []byte {
 	return buf.Bytes()
 }
 
+// copyMessage 复制消息对象，并修改Header字段
+func copyMessage(msg Message, opts ...MessageOption) Message {
+	header := msg.Header()
+	header.Extensions = append([]HeaderExtension{}, header.Extensions...)
+	for _, opt := range opts {
+		opt(&header)
+	}
+	return &message{
+		header:   &header,
+		unionId:  msg.UnionId(),
+		_unionId: splitUnionId(msg.UnionId()),
+		body:     msg.Body(),
+	}
+}
+
 func splitUnionId(unionId string) []string {
 	_unionId := strings.Split(unionId, ":")
 	remains := 4 - len(_unionId)
@@ -125,14 +293,19 @@ func splitUnionId(unionId string) []string {
 }
 
 // 创建消息对象
-func NewMessageByUnionId(unionId string, bodyBytes []byte, eventId int64) Message {
+func NewMessageByUnionId(unionId string, bodyBytes []byte, eventId int64, opts ...MessageOption) Message {
+	header := &Header{
+		Magic:      FrameMagic,
+		Version:    FrameVersion,
+		ControlVar: FrameVarData,
+		EventId:    eventId,
+		Timestamp:  time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	for _, opt := range opts {
+		opt(header)
+	}
 	return &message{
-		header: &Header{
-			Magic:      FrameMagic,
-			Version:    FrameVersion,
-			ControlVar: FrameVarData,
-			EventId:    eventId,
-		},
+		header:   header,
 		unionId:  unionId,
 		_unionId: splitUnionId(unionId),
 		body:     bodyBytes,
@@ -140,39 +313,77 @@ func NewMessageByUnionId(unionId string, bodyBytes []byte, eventId int64) Messag
 }
 
 // 创建消息对象
-func NewMessage(nodeId, groupId, majorId, minorId string, bodyBytes []byte, eventId int64) Message {
+func NewMessage(nodeId, groupId, majorId, minorId string, bodyBytes []byte, eventId int64, opts ...MessageOption) Message {
 	return NewMessageByUnionId(
 		MakeUnionId(nodeId, groupId, majorId, minorId),
 		bodyBytes,
-		eventId)
+		eventId,
+		opts...)
 }
 
-// 解析消息对象
+// 解析消息对象。消息格式错误时Panic，须处理错误的场景使用 ParseMessageE 函数。
 func ParseMessage(data []byte) Message {
-	reader := bufio.NewReader(bytes.NewReader(data))
-	magic, _ := reader.ReadByte()
-	version, _ := reader.ReadByte()
-	vars, _ := reader.ReadByte()
-	eventId := make([]byte, eventIdByteSize)
-	if _, err := reader.Read(eventId); nil != err {
+	msg, err := ParseMessageE(data)
+	if nil != err {
 		panic(err)
 	}
-	uid, _ := reader.ReadBytes(FrameEmpty)
-	body := make([]byte, len(data)-(3 /*Magic+Ver+Var*/ +eventIdByteSize)-len(uid))
-	if _, err := reader.Read(body); nil != err {
-		panic(err)
+	return msg
+}
+
+// 解析消息对象。根据Header中的版本号选择解析格式，消息格式错误时返回 ErrFrame* 错误。
+func ParseMessageE(data []byte) (Message, error) {
+	if len(data) < frameHeaderSize {
+		return nil, ErrFrameTruncatedHeader
+	}
+	if FrameMagic != data[0] {
+		return nil, ErrFrameBadMagic
+	}
+	switch data[1] {
+	case FrameVersionV1:
+		return parseMessageV1(data)
+
+	case FrameVersionV2:
+		return parseMessageV2(data)
+
+	default:
+		return nil, ErrFrameUnsupportedVersion
 	}
-	unionId := string(uid[:len(uid)-1])
+}
+
+// verifyFrameVersion 检查帧格式版本是否支持，0表示使用默认版本
+func verifyFrameVersion(version byte) error {
+	switch version {
+	case 0, FrameVersionV1, FrameVersionV2:
+		return nil
+
+	default:
+		return fmt.Errorf("不支持的帧格式版本：%d", version)
+	}
+}
+
+func parseMessageV1(data []byte) (Message, error) {
+	remains := data[frameHeaderSize:]
+	idx := bytes.IndexByte(remains, FrameEmpty)
+	if idx < 0 {
+		return nil, ErrFrameMissingUnionIdEnd
+	}
+	unionId := string(remains[:idx])
+	body := make([]byte, len(remains)-idx-1)
+	copy(body, remains[idx+1:])
 	return &message{
-		header: &Header{
-			Magic:      magic,
-			Version:    version,
-			ControlVar: vars,
-			EventId:    decodeInt64(eventId),
-		},
+		header:   parseHeader(data),
 		unionId:  unionId,
 		_unionId: splitUnionId(unionId),
 		body:     body,
+	}, nil
+}
+
+func parseHeader(data []byte) *Header {
+	return &Header{
+		Magic:      data[0],
+		Version:    data[1],
+		ControlVar: data[2],
+		EventId:    decodeInt64(data[3:frameHeaderSize]),
 	}
 }
 
@@ -2,9 +2,12 @@ package edgex
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"hash/crc32"
 	"testing"
+	"time"
 )
 
 //
@@ -90,3 +93,140 @@ func TestNewMessage(t *testing.T) {
 
 	check(parsed)
 }
+
+func TestParseMessageE(t *testing.T) {
+	data := NewMessage("CHEN", "NODE", "A", "B", []byte{0xAA, 0xBB}, 2019).Bytes()
+
+	check := func(frame []byte, excepted error) {
+		if _, err := ParseMessageE(frame); excepted != err {
+			t.Errorf("Error not match, except: %v, was: %v", excepted, err)
+		}
+	}
+
+	check(data, nil)
+	check(nil, ErrFrameTruncatedHeader)
+	check(data[:frameHeaderSize-1], ErrFrameTruncatedHeader)
+	check(append([]byte{0x00}, data[1:]...), ErrFrameBadMagic)
+	check(append([]byte{FrameMagic, 0x7F}, data[2:]...), ErrFrameUnsupportedVersion)
+	check(data[:frameHeaderSize+3], ErrFrameMissingUnionIdEnd)
+
+	// Empty body
+	if msg, err := ParseMessageE(data[:len(data)-2]); nil != err || 0 != len(msg.Body()) {
+		t.Error("Empty body not match, was: ", err)
+	}
+}
+
+func TestMessageV2(t *testing.T) {
+	body := []byte{FrameEmpty, 0xAA, FrameEmpty, 0xBB}
+	msg := NewMessage("CHEN", "NODE", "A", "", body, 2019,
+		WithFrameVersion(FrameVersionV2),
+		WithHeaderExtension(0x80, []byte("EXT")))
+	data := msg.Bytes()
+
+	parsed, err := ParseMessageE(data)
+	if nil != err {
+		t.Fatal("Parse failed: ", err)
+	}
+	header := parsed.Header()
+	if header.Version != FrameVersionV2 || header.ControlVar != FrameVarData || header.EventId != 2019 {
+		t.Error("Header not match, was: ", header)
+	}
+	if 1 != len(header.Extensions) || 0x80 != header.Extensions[0].Tag || "EXT" != string(header.Extensions[0].Value) {
+		t.Error("Extensions not match, was: ", header.Extensions)
+	}
+	if msg.UnionId() != parsed.UnionId() {
+		t.Error("UnionId not match, was: ", parsed.UnionId())
+	}
+	if !bytes.Equal(body, parsed.Body()) {
+		t.Error("Body not match, was", hex.EncodeToString(parsed.Body()))
+	}
+
+	// Errors
+	broken := append([]byte{}, data...)
+	broken[len(broken)-crc32ByteSize-1] ^= 0xFF
+	if _, err := ParseMessageE(broken); ErrFrameChecksum != err {
+		t.Error("Checksum error not match, was: ", err)
+	}
+	if _, err := ParseMessageE(data[:frameHeaderSize+2]); ErrFrameTruncated != err {
+		t.Error("Truncated error not match, was: ", err)
+	}
+	// Body长度字段超出数据长度
+	huge := NewMessage("CHEN", "NODE", "A", "", nil, 2019, WithFrameVersion(FrameVersionV2)).Bytes()
+	binary.BigEndian.PutUint32(huge[len(huge)-crc32ByteSize-4:], 0xFFFFFFFF)
+	binary.BigEndian.PutUint32(huge[len(huge)-crc32ByteSize:], crc32.ChecksumIEEE(huge[:len(huge)-crc32ByteSize]))
+	if _, err := ParseMessageE(huge); ErrFrameTruncated != err {
+		t.Error("Body length error not match, was: ", err)
+	}
+
+	// V1 ignores extensions
+	v1 := NewMessage("CHEN", "NODE", "A", "", body, 2019, WithHeaderExtension(0x80, []byte("EXT")))
+	if parsed := ParseMessage(v1.Bytes()); FrameVersionV1 != parsed.Header().Version || 0 != len(parsed.Header().Extensions) {
+		t.Error("V1 header not match, was: ", parsed.Header())
+	}
+}
+
+func TestMessageOptionLimits(t *testing.T) {
+	mustPanic := func(name string, fn func()) {
+		defer func() {
+			if nil == recover() {
+				t.Errorf("%s should panic", name)
+			}
+		}()
+		fn()
+	}
+	mustPanic("Reserved extension tag", func() { WithHeaderExtension(0x7F, nil) })
+	mustPanic("TTL overflow", func() { WithTTL(MaxTTL + time.Millisecond) })
+	WithHeaderExtension(HeaderExtensionUserMin, nil)
+	WithTTL(MaxTTL)
+}
+
+func TestFrameVersion(t *testing.T) {
+	for _, version := range []byte{0, FrameVersionV1, FrameVersionV2} {
+		if err := verifyFrameVersion(version); nil != err {
+			t.Errorf("Version %d should be supported, was: %s", version, err)
+		}
+	}
+	if err := verifyFrameVersion(0x03); nil == err {
+		t.Error("Version 3 should not be supported")
+	}
+	defer func() {
+		if nil == recover() {
+			t.Error("WithFrameVersion should panic on unsupported version")
+		}
+	}()
+	WithFrameVersion(0x7F)
+}
+
+func TestMessageHeaderFields(t *testing.T) {
+	now := time.Unix(1557046800, 123*int64(time.Millisecond))
+	msg := NewMessage("CHEN", "NODE", "A", "", []byte("{}"), 2019,
+		WithFrameVersion(FrameVersionV2),
+		WithTimestamp(now),
+		WithContentType(ContentTypeJSON),
+		WithTTL(time.Second*3),
+		WithReplyTo("CALLER"),
+		WithHeaderExtension(0x80, []byte{0x01}))
+
+	parsed := ParseMessage(msg.Bytes())
+	if !now.Equal(parsed.Timestamp()) {
+		t.Error("Timestamp not match, was: ", parsed.Timestamp())
+	}
+	if ContentTypeJSON != parsed.ContentType() {
+		t.Error("ContentType not match, was: ", parsed.ContentType())
+	}
+	if time.Second*3 != parsed.TTL() {
+		t.Error("TTL not match, was: ", parsed.TTL())
+	}
+	if "CALLER" != parsed.ReplyTo() {
+		t.Error("ReplyTo not match, was: ", parsed.ReplyTo())
+	}
+	if 1 != len(parsed.Header().Extensions) || 0x80 != parsed.Header().Extensions[0].Tag {
+		t.Error("Extensions not match, was: ", parsed.Header().Extensions)
+	}
+
+	// V1 does not carry optional fields
+	v1 := ParseMessage(NewMessage("CHEN", "NODE", "A", "", nil, 2019, WithReplyTo("CALLER")).Bytes())
+	if !v1.Timestamp().IsZero() || "" != v1.ReplyTo() || 0 != v1.TTL() {
+		t.Error("V1 header not match, was: ", v1.Header())
+	}
+}
@@ -0,0 +1,190 @@
+package edgex
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"math"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+// 版本2消息格式：
+// | Magic(1) | Version(1) | ControlVar(1) | EventId(8) |
+// | UnionIdLen(2) | UnionId(N) |
+// | ExtensionsLen(2) | [ Tag(1) | Len(2) | Value(N) ]... |
+// | BodyLen(4) | Body(N) |
+// | CRC32(4) |
+// 所有长度字段均为BigEndian编码；CRC32使用IEEE多项式，校验范围为CRC32字段之前的全部字节。
+
+const (
+	crc32ByteSize = 4
+)
+
+// Header可选字段使用的系统保留扩展Tag
+const (
+	extTagTimestamp   byte = 0x01
+	extTagContentType      = 0x02
+	extTagTTL              = 0x03
+	extTagReplyTo          = 0x04
+)
+
+func encodeMessageV2(m *message) []byte {
+	extensions := new(bytes.Buffer)
+	for _, ext := range append(encodeHeaderFields(m.header), m.header.Extensions...) {
+		checkFieldSize(len(ext.Value), math.MaxUint16, "HeaderExtension")
+		extensions.WriteByte(ext.Tag)
+		extensions.Write(encodeUint16(uint16(len(ext.Value))))
+		extensions.Write(ext.Value)
+	}
+	checkFieldSize(len(m.unionId), math.MaxUint16, "UnionId")
+	checkFieldSize(extensions.Len(), math.MaxUint16, "HeaderExtensions")
+	checkFieldSize(len(m.body), math.MaxUint32, "Body")
+
+	buf := new(bytes.Buffer)
+	buf.WriteByte(m.header.Magic)
+	buf.WriteByte(m.header.Version)
+	buf.WriteByte(m.header.ControlVar)
+	buf.Write(encodeInt64(m.header.EventId))
+	buf.Write(encodeUint16(uint16(len(m.unionId))))
+	buf.WriteString(m.unionId)
+	buf.Write(encodeUint16(uint16(extensions.Len())))
+	buf.Write(extensions.Bytes())
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(m.body)))
+	buf.Write(size)
+	buf.Write(m.body)
+	crc := make([]byte, crc32ByteSize)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(buf.Bytes()))
+	buf.Write(crc)
+	return buf.Bytes()
+}
+
+func parseMessageV2(data []byte) (Message, error) {
+	if len(data) < frameHeaderSize+crc32ByteSize {
+		return nil, ErrFrameTruncated
+	}
+	content := data[:len(data)-crc32ByteSize]
+	if crc32.ChecksumIEEE(content) != binary.BigEndian.Uint32(data[len(content):]) {
+		return nil, ErrFrameChecksum
+	}
+	reader := &frameReader{data: content, offset: frameHeaderSize}
+	uid := reader.next(int(reader.uint16()))
+	extensions := reader.next(int(reader.uint16()))
+	// Body长度须在转换为int之前检查，避免超大长度值溢出
+	bodyLen := reader.uint32()
+	if uint64(bodyLen) > uint64(len(content)-reader.offset) {
+		return nil, ErrFrameTruncated
+	}
+	body := reader.next(int(bodyLen))
+	if reader.failed || reader.offset != len(content) {
+		return nil, ErrFrameTruncated
+	}
+	header := parseHeader(data)
+	extReader := &frameReader{data: extensions}
+	for extReader.offset < len(extensions) {
+		tag := extReader.next(1)
+		value := extReader.next(int(extReader.uint16()))
+		if extReader.failed {
+			return nil, ErrFrameTruncated
+		}
+		if !decodeHeaderField(header, tag[0], value) {
+			header.Extensions = append(header.Extensions, HeaderExtension{
+				Tag:   tag[0],
+				Value: append([]byte{}, value...),
+			})
+		}
+	}
+	unionId := string(uid)
+	return &message{
+		header:   header,
+		unionId:  unionId,
+		_unionId: splitUnionId(unionId),
+		body:     append([]byte{}, body...),
+	}, nil
+}
+
+func encodeHeaderFields(header *Header) []HeaderExtension {
+	fields := make([]HeaderExtension, 0, 4)
+	if 0 != header.Timestamp {
+		fields = append(fields, HeaderExtension{Tag: extTagTimestamp, Value: encodeInt64(header.Timestamp)})
+	}
+	if ContentTypeUnknown != header.ContentType {
+		fields = append(fields, HeaderExtension{Tag: extTagContentType, Value: []byte{header.ContentType}})
+	}
+	if 0 != header.TTL {
+		ttl := make([]byte, 4)
+		binary.BigEndian.PutUint32(ttl, header.TTL)
+		fields = append(fields, HeaderExtension{Tag: extTagTTL, Value: ttl})
+	}
+	if "" != header.ReplyTo {
+		fields = append(fields, HeaderExtension{Tag: extTagReplyTo, Value: []byte(header.ReplyTo)})
+	}
+	return fields
+}
+
+// decodeHeaderField 解析系统保留扩展Tag到Header字段；非保留Tag或长度不匹配时返回false
+func decodeHeaderField(header *Header, tag byte, value []byte) bool {
+	switch {
+	case extTagTimestamp == tag && eventIdByteSize == len(value):
+		header.Timestamp = decodeInt64(value)
+
+	case extTagContentType == tag && 1 == len(value):
+		header.ContentType = value[0]
+
+	case extTagTTL == tag && 4 == len(value):
+		header.TTL = binary.BigEndian.Uint32(value)
+
+	case extTagReplyTo == tag:
+		header.ReplyTo = string(value)
+
+	default:
+		return false
+	}
+	return true
+}
+
+// frameReader 按长度顺序读取字段，越界时标记失败并返回空数据
+type frameReader struct {
+	data   []byte
+	offset int
+	failed bool
+}
+
+func (r *frameReader) next(size int) []byte {
+	if r.failed || size < 0 || size > len(r.data)-r.offset {
+		r.failed = true
+		return nil
+	}
+	bs := r.data[r.offset : r.offset+size]
+	r.offset += size
+	return bs
+}
+
+func (r *frameReader) uint16() uint16 {
+	if bs := r.next(2); nil != bs {
+		return binary.BigEndian.Uint16(bs)
+	}
+	return 0
+}
+
+func (r *frameReader) uint32() uint32 {
+	if bs := r.next(4); nil != bs {
+		return binary.BigEndian.Uint32(bs)
+	}
+	return 0
+}
+
+func encodeUint16(num uint16) []byte {
+	bs := make([]byte, 2)
+	binary.BigEndian.PutUint16(bs, num)
+	return bs
+}
+
+func checkFieldSize(size int, max uint64, fieldName string) {
+	if uint64(size) > max {
+		log.Panicf("消息字段%s长度超出限制：%d", fieldName, size)
+	}
+}
@@ -2,9 +2,15 @@ package edgex
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"fmt"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"io/ioutil"
+	"math/rand"
 	"runtime"
+	"sync"
 	"time"
 )
 
@@ -12,11 +18,11 @@ import (
 // Author: 陈哈哈 yoojiachen@gmail.com
 //
 
-func mqttSetOptions(opts *mqtt.ClientOptions, scoped *Globals, onConnectedFunc func(mqtt.Client)) {
-	opts.AddBroker(scoped.MqttBroker)
+func mqttSetOptions(opts *mqtt.ClientOptions, scoped *Globals, onConnectedFunc func(mqtt.Client), onLostFunc func(error)) {
 	opts.SetKeepAlive(scoped.MqttKeepAlive)
 	opts.SetPingTimeout(scoped.MqttPingTimeout)
-	opts.SetAutoReconnect(scoped.MqttAutoReconnect)
+	// 断线重连由Transport按Broker健康状态处理
+	opts.SetAutoReconnect(false)
 	opts.SetConnectTimeout(scoped.MqttConnectTimeout)
 	opts.SetCleanSession(scoped.MqttCleanSession)
 	opts.SetMaxReconnectInterval(scoped.MqttReconnectInterval)
@@ -26,6 +32,7 @@ func mqttSetOptions(opts *mqtt.ClientOptions, scoped *Globals, onConnectedFunc f
 	}
 	opts.SetConnectionLostHandler(func(client mqtt.Client, err error) {
 		log.Error("Mqtt客户端：丢失连接[CONNECTION-LOST]（" + err.Error() + ")")
+		onLostFunc(err)
 	})
 	opts.SetOnConnectHandler(func(client mqtt.Client) {
 		log.Debug("Mqtt客户端：已连接[CONNECTED]")
@@ -33,9 +40,40 @@ func mqttSetOptions(opts *mqtt.ClientOptions, scoped *Globals, onConnectedFunc f
 	})
 }
 
+// mqttTLSConfig 根据全局配置创建TLS配置；未设置任何TLS参数时返回nil
+func mqttTLSConfig(scoped *Globals) (*tls.Config, error) {
+	if "" == scoped.MqttCAFile && "" == scoped.MqttCertFile && "" == scoped.MqttKeyFile &&
+		"" == scoped.MqttServerName && !scoped.MqttInsecureSkipVerify {
+		return nil, nil
+	}
+	config := &tls.Config{
+		ServerName:         scoped.MqttServerName,
+		InsecureSkipVerify: scoped.MqttInsecureSkipVerify,
+	}
+	if "" != scoped.MqttCAFile {
+		pem, err := ioutil.ReadFile(scoped.MqttCAFile)
+		if nil != err {
+			return nil, fmt.Errorf("read ca file %s: %s", scoped.MqttCAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in ca file %s", scoped.MqttCAFile)
+		}
+		config.RootCAs = pool
+	}
+	if "" != scoped.MqttCertFile || "" != scoped.MqttKeyFile {
+		cert, err := tls.LoadX509KeyPair(scoped.MqttCertFile, scoped.MqttKeyFile)
+		if nil != err {
+			return nil, fmt.Errorf("load client cert %s: %s", scoped.MqttCertFile, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
 ////
 
-func createStateMessage(state VirtualNodeState) Message {
+func createStateMessage(globals *Globals, state VirtualNodeState) Message {
 	if "" == state.UnionId {
 		state.UnionId = MakeUnionId(state.NodeId, state.BoardId, state.MajorId, state.MinorId)
 	}
@@ -43,25 +81,35 @@ func createStateMessage(state VirtualNodeState) Message {
 	if nil != err {
 		log.Panic("数据序列化错误", err)
 	}
-	return NewMessageByUnionId(state.UnionId, stateJSON, 0)
+	return NewMessageByUnionId(state.UnionId, stateJSON, 0,
+		WithFrameVersion(globals.FrameVersion))
 }
 
-func mqttSendNodeState(client mqtt.Client, state VirtualNodeState) {
-	token := client.Publish(
-		TopicOfStates(state.NodeId),
-		0,
-		false,
-		createStateMessage(state).Bytes(),
+func mqttSendNodeState(globals *Globals, transport Transport, retainedTopics *sync.Map, state VirtualNodeState) {
+	message := createStateMessage(globals, state)
+	topic := TopicOfVirtualStates(message.UnionId())
+	err := transport.Publish(context.Background(),
+		topic,
+		globals.NodeStateQoS,
+		globals.NodeStateRetained,
+		message.Bytes(),
 	)
-	if token.Wait() && nil != token.Error() {
-		log.Error("NodeState: 发送消息出错", token.Error())
+	if nil != err {
+		log.Error("NodeState: 发送消息出错", err)
+	} else if globals.NodeStateRetained {
+		retainedTopics.Store(topic, struct{}{})
 	}
 }
 
-func mqttSendNodeProperties(globals *Globals, client mqtt.Client, properties MainNodeProperties) {
-	checkRequired(properties.NodeType, "NodeType是必须的")
+func mqttSendNodeProperties(globals *Globals, transport Transport, retainedTopics *sync.Map, properties MainNodeProperties) {
+	// 由定时任务及Discovery请求触发上报，数据错误时记录日志，不中断进程
+	if "" == properties.NodeType {
+		log.Error("NodeProperties: 缺少NodeType数据")
+		return
+	}
 	if 0 == len(properties.VirtualNodes) {
-		log.Panic("NodeProperties: 缺少虚拟节点数据")
+		log.Error("NodeProperties: 缺少虚拟节点数据")
+		return
 	}
 	if "" == properties.HostOS {
 		properties.HostOS = runtime.GOOS
@@ -82,29 +130,63 @@ func mqttSendNodeProperties(globals *Globals, client mqtt.Client, properties Mai
 	} else if globals.LogVerbose {
 		log.Debug("NodeProperties: " + string(propertiesJSON))
 	}
-	token := client.Publish(
-		TopicOfProperties(nodeId),
-		0,
-		false,
-		NewMessage(nodeId, nodeId, nodeId, "", propertiesJSON, 0).Bytes(),
+	topic := TopicOfProperties(nodeId)
+	err = transport.Publish(context.Background(),
+		topic,
+		globals.NodeStateQoS,
+		globals.NodeStateRetained,
+		NewMessage(nodeId, nodeId, nodeId, "", propertiesJSON, 0,
+			WithFrameVersion(globals.FrameVersion)).Bytes(),
 	)
-	if token.Wait() && nil != token.Error() {
-		log.Error("发送消息出错", token.Error())
+	if nil != err {
+		log.Error("发送消息出错", err)
+	} else if globals.NodeStateRetained {
+		retainedTopics.Store(topic, struct{}{})
 	}
 }
 
-func scheduleSendProperties(shutdown context.Context, inspectTask func()) {
-	// 在1分钟内上报Properties消息
-	ticker := time.NewTicker(time.Second * 10)
+// mqttClearRetained 发送空的保留消息，清除Broker中已保留的节点状态及属性消息
+func mqttClearRetained(globals *Globals, transport Transport, retainedTopics *sync.Map) {
+	retainedTopics.Range(func(topic, _ interface{}) bool {
+		err := transport.Publish(context.Background(), topic.(string), globals.NodeStateQoS, true, []byte{})
+		if nil != err {
+			log.Errorf("清除保留消息出错，Topic：%s, 错误：%s", topic, err)
+		} else {
+			retainedTopics.Delete(topic)
+		}
+		return true
+	})
+}
+
+const (
+	DefaultPropertiesInterval = time.Second * 10
+	DefaultPropertiesDuration = time.Minute
+)
+
+// scheduleSendProperties 按PropertiesInterval周期上报Properties消息，持续PropertiesDuration时长；
+// PropertiesDuration小于0时持续上报，直到shutdown被取消。
+func scheduleSendProperties(shutdown context.Context, globals *Globals, clock Clock, inspectTask func()) {
+	interval := globals.PropertiesInterval
+	if 0 >= interval {
+		interval = DefaultPropertiesInterval
+	}
+	duration := globals.PropertiesDuration
+	if 0 == duration {
+		duration = DefaultPropertiesDuration
+	}
+	// 在持续时长内的上报次数，至少1次
+	ticks := int((duration - 1) / interval)
+	if ticks < 1 {
+		ticks = 1
+	}
+	ticker := clock.NewTicker(interval)
 	defer ticker.Stop()
 
-	tick := 1
-	for {
+	for tick := 1; ; tick++ {
 		select {
-		case <-ticker.C:
+		case <-ticker.C():
 			inspectTask()
-			tick++
-			if tick >= 6 {
+			if 0 < duration && tick >= ticks {
 				return
 			}
 
@@ -114,20 +196,44 @@ func scheduleSendProperties(shutdown context.Context, inspectTask func()) {
 	}
 }
 
-func mqttAwaitConnection(client mqtt.Client, maxRetry int) {
-	timer := time.NewTimer(time.Second)
+// mqttAwaitConnection 连续重试连接Broker，重试间隔按指数增长并加入随机抖动，最大不超过MqttMaxRetryInterval。
+// 首次立即连接，仅在重试之间等待；达到最大重试次数或ctx被取消时返回错误；
+// MqttMaxRetry为 MqttRetryForever 时持续重试直到ctx被取消。
+func mqttAwaitConnection(ctx context.Context, transport Transport, globals *Globals) error {
+	timer := time.NewTimer(0)
 	defer timer.Stop()
-	for i := 1; i <= maxRetry; i++ {
-		<-timer.C
-		if token := client.Connect(); token.Wait() && token.Error() != nil {
-			if i == maxRetry {
-				log.Errorf("[%d] Mqtt客户端连接失败，最大次数：%v", i, token.Error())
-			} else {
-				log.Debugf("[%d] Mqtt客户端尝试重新连接，失败：%v", i, token.Error())
-			}
-			timer.Reset(time.Second * time.Duration(i))
-		} else {
-			break
+	for i := 1; MqttRetryForever == globals.MqttMaxRetry || i <= globals.MqttMaxRetry; i++ {
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		err := transport.Connect()
+		if nil == err {
+			return nil
+		}
+		if i == globals.MqttMaxRetry {
+			log.Errorf("[%d] Mqtt客户端连接失败，最大次数：%v", i, err)
+			return fmt.Errorf("mqtt connect failed after %d attempts: %s", i, err)
+		}
+		delay := retryBackoff(i, globals.MqttMaxRetryInterval)
+		log.Debugf("[%d] Mqtt客户端尝试重新连接，失败：%v，%s后重试", i, err, delay)
+		timer.Reset(delay)
+	}
+	return ErrTransportNotConnected
+}
+
+// retryBackoff 返回第n次重试的等待时间：以1秒为基数按指数增长，不超过maxInterval，并在[50%, 100%]范围内随机抖动
+func retryBackoff(n int, maxInterval time.Duration) time.Duration {
+	if maxInterval <= 0 {
+		maxInterval = time.Second * 30
+	}
+	delay := maxInterval
+	if n < 32 {
+		if d := time.Second << uint(n-1); d < maxInterval {
+			delay = d
 		}
 	}
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
 }
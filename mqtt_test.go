@@ -0,0 +1,94 @@
+package edgex
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+// writeTestCert 生成自签名证书及私钥文件，返回证书及私钥的文件路径
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if nil != err {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "edgex-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if nil != err {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if nil != err {
+		t.Fatal(err)
+	}
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_ = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	_ = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	return certFile, keyFile
+}
+
+func TestMqttTLSConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edgex-tls")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+	invalidFile := filepath.Join(dir, "invalid.pem")
+	_ = ioutil.WriteFile(invalidFile, []byte("NOT A PEM"), 0600)
+	missingFile := filepath.Join(dir, "missing.pem")
+
+	if config, err := mqttTLSConfig(&Globals{}); nil != config || nil != err {
+		t.Error("TLS should be disabled without options, was: ", config, err)
+	}
+	// CA
+	if config, err := mqttTLSConfig(&Globals{MqttCAFile: certFile}); nil != err || nil == config.RootCAs {
+		t.Error("CA not loaded, was: ", err)
+	}
+	if _, err := mqttTLSConfig(&Globals{MqttCAFile: invalidFile}); nil == err {
+		t.Error("Invalid PEM should fail")
+	}
+	if _, err := mqttTLSConfig(&Globals{MqttCAFile: missingFile}); nil == err {
+		t.Error("Missing CA file should fail")
+	}
+	// Client cert pair
+	if config, err := mqttTLSConfig(&Globals{MqttCertFile: certFile, MqttKeyFile: keyFile}); nil != err || 1 != len(config.Certificates) {
+		t.Error("Client cert not loaded, was: ", err)
+	}
+	if _, err := mqttTLSConfig(&Globals{MqttCertFile: certFile}); nil == err {
+		t.Error("Client cert without key should fail")
+	}
+	if _, err := mqttTLSConfig(&Globals{MqttCertFile: certFile, MqttKeyFile: invalidFile}); nil == err {
+		t.Error("Invalid key should fail")
+	}
+
+	// Context初始化时立即返回错误，不尝试连接
+	unreachable := new(unreachableTransport)
+	ctx := CreateContext(&Globals{MqttMaxRetry: 3, MqttCAFile: missingFile}, WithTransport(func(clientId string, globals *Globals) Transport {
+		return unreachable
+	}))
+	if err := ctx.InitialE(context.Background(), map[string]interface{}{"NodeId": "NODE"}); nil == err || 0 != unreachable.attempts {
+		t.Error("Initial should fail before connecting, was: ", err, unreachable.attempts)
+	}
+}
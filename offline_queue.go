@@ -0,0 +1,361 @@
+package edgex
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+// OfflineDropPolicy 离线队列已满时的丢弃策略
+type OfflineDropPolicy int
+
+const (
+	OfflineDropOldest OfflineDropPolicy = iota // 丢弃最早入队的消息，为新消息腾出空间
+	OfflineDropNewest                          // 丢弃新消息，并返回 ErrOfflineQueueFull 错误
+)
+
+const (
+	DefaultOfflineQueueMaxBytes = 64 * 1024 * 1024
+
+	offlineFileExt    = ".msg"
+	offlineTmpExt     = ".tmp"
+	offlineHeaderSize = 1 /*QoS*/ + 1 /*Retained*/ + 8 /*Queued*/ + 2 /*TopicLen*/
+)
+
+var (
+	ErrOfflineQueueFull = errors.New("offline queue full")
+)
+
+// OfflineQueueStats 离线队列统计数据
+type OfflineQueueStats struct {
+	Pending      int    // 队列中等待重发的消息数量
+	PendingBytes int64  // 队列中等待重发的消息字节数
+	Queued       uint64 // 累计入队的消息数量
+	Replayed     uint64 // 累计重发成功的消息数量
+	Dropped      uint64 // 累计因队列已满或超过有效期而丢弃的消息数量
+}
+
+type offlineRecord struct {
+	file   string
+	size   int64
+	queued time.Time
+}
+
+// offlineQueue 是以目录存储的持久化离线消息队列，每条消息一个文件，文件名为递增序号。
+// 连接断开期间发布的消息写入队列，重新连接后按入队顺序重发。
+type offlineQueue struct {
+	dir      string
+	maxBytes int64
+	maxAge   time.Duration
+	policy   OfflineDropPolicy
+	clock    Clock
+
+	mutex     sync.Mutex
+	records   []offlineRecord
+	bytes     int64
+	seq       uint64
+	replaying bool
+	closed    bool
+	stats     OfflineQueueStats
+}
+
+// openOfflineQueue 打开离线队列目录，并加载上次运行时未重发的消息
+func openOfflineQueue(globals *Globals, clock Clock) (*offlineQueue, error) {
+	q := &offlineQueue{
+		dir:      globals.OfflineQueueDir,
+		maxBytes: globals.OfflineQueueMaxBytes,
+		maxAge:   globals.OfflineQueueMaxAge,
+		policy:   globals.OfflineDropPolicy,
+		clock:    clock,
+		records:  make([]offlineRecord, 0),
+	}
+	if 0 >= q.maxBytes {
+		q.maxBytes = DefaultOfflineQueueMaxBytes
+	}
+	if err := os.MkdirAll(q.dir, 0755); nil != err {
+		return nil, err
+	}
+	// ReadDir 按文件名排序，即入队顺序
+	files, err := ioutil.ReadDir(q.dir)
+	if nil != err {
+		return nil, err
+	}
+	for _, info := range files {
+		name := info.Name()
+		path := filepath.Join(q.dir, name)
+		if strings.HasSuffix(name, offlineTmpExt) {
+			_ = os.Remove(path)
+			continue
+		}
+		if info.IsDir() || !strings.HasSuffix(name, offlineFileExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, offlineFileExt), 10, 64)
+		if nil != err {
+			continue
+		}
+		queued, err := readOfflineQueued(path)
+		if nil != err {
+			log.Errorf("离线消息文件损坏，已删除：%s, 错误：%s", path, err)
+			_ = os.Remove(path)
+			continue
+		}
+		q.records = append(q.records, offlineRecord{file: path, size: info.Size(), queued: queued})
+		q.bytes += info.Size()
+		if seq >= q.seq {
+			q.seq = seq + 1
+		}
+	}
+	q.expire()
+	if 0 < len(q.records) {
+		log.Infof("加载离线消息：%d条，目录：%s", len(q.records), q.dir)
+	}
+	return q, nil
+}
+
+// publish 连接正常且队列为空时直接发布消息；否则写入队列，等待重新连接后按顺序重发。
+// 直接发布因ctx被取消或超时而失败时，返回ctx的错误，消息不写入队列。
+func (q *offlineQueue) publish(ctx context.Context, transport Transport, topic string, qos uint8, retained bool, payload []byte) error {
+	q.mutex.Lock()
+	if !q.replaying && 0 == len(q.records) && transport.IsConnected() {
+		q.mutex.Unlock()
+		err := transport.Publish(ctx, topic, qos, retained, payload)
+		if nil == err {
+			return nil
+		}
+		// 调用方取消或超时导致的失败，不写入离线队列
+		if nil != ctx.Err() {
+			return ctx.Err()
+		}
+		log.Error("发布消息出错，写入离线队列：", err)
+		q.mutex.Lock()
+	}
+	defer q.mutex.Unlock()
+	if err := q.enqueue(topic, qos, retained, payload); nil != err {
+		return err
+	}
+	// 队列中有遗留消息，且当前已连接时，由本次发布触发重发
+	if !q.replaying && !q.closed && transport.IsConnected() {
+		q.replaying = true
+		go q.replay(transport)
+	}
+	return nil
+}
+
+// startReplay 连接成功后调用，按入队顺序重发队列中的消息
+func (q *offlineQueue) startReplay(transport Transport) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.replaying || q.closed || 0 == len(q.records) {
+		return
+	}
+	q.replaying = true
+	go q.replay(transport)
+}
+
+func (q *offlineQueue) replay(transport Transport) {
+	for {
+		q.mutex.Lock()
+		q.expire()
+		if q.closed || 0 == len(q.records) {
+			q.replaying = false
+			q.mutex.Unlock()
+			return
+		}
+		record := q.records[0]
+		q.mutex.Unlock()
+
+		topic, qos, retained, payload, err := readOfflineFile(record.file)
+		if nil != err {
+			log.Errorf("读取离线消息出错，已丢弃：%s, 错误：%s", record.file, err)
+			q.mutex.Lock()
+			if q.remove(record) {
+				q.stats.Dropped++
+			}
+			q.mutex.Unlock()
+			continue
+		}
+		if err := transport.Publish(context.Background(), topic, qos, retained, payload); nil != err {
+			log.Error("重发离线消息出错，等待重新连接：", err)
+			q.mutex.Lock()
+			q.replaying = false
+			q.mutex.Unlock()
+			return
+		}
+		q.mutex.Lock()
+		if q.remove(record) {
+			q.stats.Replayed++
+		}
+		q.mutex.Unlock()
+	}
+}
+
+// enqueue 写入消息文件。须在持有锁时调用。
+func (q *offlineQueue) enqueue(topic string, qos uint8, retained bool, payload []byte) error {
+	if len(topic) > math.MaxUint16 {
+		return fmt.Errorf("离线消息Topic长度超出限制：%d", len(topic))
+	}
+	q.expire()
+	now := q.clock.Now()
+	data := encodeOfflineRecord(topic, qos, retained, now, payload)
+	size := int64(len(data))
+	for q.bytes+size > q.maxBytes {
+		if OfflineDropNewest == q.policy || 0 == len(q.records) {
+			q.stats.Dropped++
+			return ErrOfflineQueueFull
+		}
+		if q.remove(q.records[0]) {
+			q.stats.Dropped++
+		}
+	}
+	path := filepath.Join(q.dir, fmt.Sprintf("%020d%s", q.seq, offlineFileExt))
+	tmp := path + offlineTmpExt
+	if err := writeSyncFile(tmp, data); nil != err {
+		_ = os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); nil != err {
+		_ = os.Remove(tmp)
+		return err
+	}
+	// 同步目录，确保重命名在断电后仍然有效
+	if err := syncDir(q.dir); nil != err {
+		log.Error("同步离线队列目录出错：", err)
+	}
+	q.seq++
+	q.records = append(q.records, offlineRecord{file: path, size: size, queued: now})
+	q.bytes += size
+	q.stats.Queued++
+	return nil
+}
+
+// expire 丢弃超过有效期的消息。须在持有锁时调用。
+func (q *offlineQueue) expire() {
+	if 0 >= q.maxAge {
+		return
+	}
+	now := q.clock.Now()
+	for 0 < len(q.records) && now.Sub(q.records[0].queued) > q.maxAge {
+		if q.remove(q.records[0]) {
+			q.stats.Dropped++
+		}
+	}
+}
+
+// remove 删除队首消息；队首已不是指定消息（已被丢弃）时返回false。须在持有锁时调用。
+func (q *offlineQueue) remove(record offlineRecord) bool {
+	if 0 == len(q.records) || record.file != q.records[0].file {
+		return false
+	}
+	if err := os.Remove(record.file); nil != err && !os.IsNotExist(err) {
+		log.Error("删除离线消息文件出错：", err)
+	}
+	q.records = q.records[1:]
+	q.bytes -= record.size
+	return true
+}
+
+// Stats 返回离线队列统计数据
+func (q *offlineQueue) Stats() OfflineQueueStats {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	stats := q.stats
+	stats.Pending = len(q.records)
+	stats.PendingBytes = q.bytes
+	return stats
+}
+
+// close 停止重发。未重发的消息保留在目录中，下次启动时加载。
+func (q *offlineQueue) close() {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+}
+
+// writeSyncFile 写入文件并同步到磁盘
+func writeSyncFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if nil != err {
+		return err
+	}
+	if _, err := f.Write(data); nil != err {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Sync(); nil != err {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if nil != err {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+//// 消息文件格式：QoS(1) + Retained(1) + 入队时间Unix毫秒(8) + Topic长度(2) + Topic + Payload
+
+func encodeOfflineRecord(topic string, qos uint8, retained bool, queued time.Time, payload []byte) []byte {
+	data := make([]byte, offlineHeaderSize, offlineHeaderSize+len(topic)+len(payload))
+	data[0] = qos
+	if retained {
+		data[1] = 1
+	}
+	binary.BigEndian.PutUint64(data[2:10], uint64(queued.UnixNano()/int64(time.Millisecond)))
+	binary.BigEndian.PutUint16(data[10:12], uint16(len(topic)))
+	data = append(data, topic...)
+	return append(data, payload...)
+}
+
+func readOfflineQueued(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if nil != err {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	header := make([]byte, offlineHeaderSize)
+	if _, err := io.ReadFull(f, header); nil != err {
+		return time.Time{}, err
+	}
+	return decodeOfflineQueued(header), nil
+}
+
+func readOfflineFile(path string) (topic string, qos uint8, retained bool, payload []byte, err error) {
+	data, err := ioutil.ReadFile(path)
+	if nil != err {
+		return "", 0, false, nil, err
+	}
+	if len(data) < offlineHeaderSize {
+		return "", 0, false, nil, ErrFrameTruncated
+	}
+	topicLen := int(binary.BigEndian.Uint16(data[10:12]))
+	if len(data) < offlineHeaderSize+topicLen {
+		return "", 0, false, nil, ErrFrameTruncated
+	}
+	topic = string(data[offlineHeaderSize : offlineHeaderSize+topicLen])
+	return topic, data[0], 1 == data[1], data[offlineHeaderSize+topicLen:], nil
+}
+
+func decodeOfflineQueued(header []byte) time.Time {
+	ms := int64(binary.BigEndian.Uint64(header[2:10]))
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
@@ -0,0 +1,193 @@
+package edgex
+
+import (
+	"context"
+	"io/ioutil"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func TestOfflineQueueReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edgex-offline")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	broker := NewMemoryBroker()
+	received := make(chan string, 10)
+	subscriber := broker.Transport("SUB", nil)
+	_ = subscriber.Connect()
+	_ = subscriber.Subscribe("offline/#", 0, func(topic string, payload []byte) {
+		received <- string(payload)
+	})
+	publisher := broker.Transport("PUB", nil)
+	queue, err := openOfflineQueue(&Globals{OfflineQueueDir: dir}, SystemClock())
+	if nil != err {
+		t.Fatal(err)
+	}
+	publisher.OnConnected(func() {
+		queue.startReplay(publisher)
+	})
+
+	// 未连接时写入队列
+	for _, body := range []string{"A", "B", "C"} {
+		if err := queue.publish(context.Background(), publisher, "offline/test", 0, false, []byte(body)); nil != err {
+			t.Fatal("Enqueue failed: ", err)
+		}
+	}
+	if stats := queue.Stats(); 3 != stats.Pending || 3 != stats.Queued {
+		t.Fatal("Stats not match, was: ", stats)
+	}
+
+	// 重新加载目录
+	reloaded, err := openOfflineQueue(&Globals{OfflineQueueDir: dir}, SystemClock())
+	if nil != err || 3 != reloaded.Stats().Pending {
+		t.Fatal("Reload failed: ", err)
+	}
+
+	// 连接后按顺序重发
+	_ = publisher.Connect()
+	for _, expected := range []string{"A", "B", "C"} {
+		select {
+		case body := <-received:
+			if expected != body {
+				t.Error("Replay order not match, expected: ", expected, ", was: ", body)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Replay not received: ", expected)
+		}
+	}
+	awaitCondition(t, func() bool {
+		stats := queue.Stats()
+		return 0 == stats.Pending && 3 == stats.Replayed
+	})
+}
+
+func TestOfflineQueueDropPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edgex-offline")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	transport := NewMemoryBroker().Transport("PUB", nil)
+	payload := make([]byte, 100)
+	recordSize := int64(len(encodeOfflineRecord("t", 0, false, time.Now(), payload)))
+
+	// 丢弃最早的消息
+	oldest, err := openOfflineQueue(&Globals{OfflineQueueDir: dir + "/oldest", OfflineQueueMaxBytes: recordSize * 2}, SystemClock())
+	if nil != err {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := oldest.publish(context.Background(), transport, "t", 0, false, payload); nil != err {
+			t.Error("Enqueue failed: ", err)
+		}
+	}
+	if stats := oldest.Stats(); 2 != stats.Pending || 1 != stats.Dropped {
+		t.Error("Drop oldest not match, was: ", stats)
+	}
+
+	// 丢弃新消息
+	newest, err := openOfflineQueue(&Globals{OfflineQueueDir: dir + "/newest", OfflineQueueMaxBytes: recordSize * 2,
+		OfflineDropPolicy: OfflineDropNewest}, SystemClock())
+	if nil != err {
+		t.Fatal(err)
+	}
+	_ = newest.publish(context.Background(), transport, "t", 0, false, payload)
+	_ = newest.publish(context.Background(), transport, "t", 0, false, payload)
+	if err := newest.publish(context.Background(), transport, "t", 0, false, payload); ErrOfflineQueueFull != err {
+		t.Error("Should return queue full, was: ", err)
+	}
+	if stats := newest.Stats(); 2 != stats.Pending || 1 != stats.Dropped {
+		t.Error("Drop newest not match, was: ", stats)
+	}
+
+	// 超过有效期
+	clock := &steppedClock{now: time.Now()}
+	aged, err := openOfflineQueue(&Globals{OfflineQueueDir: dir + "/aged", OfflineQueueMaxAge: time.Millisecond * 10}, clock)
+	if nil != err {
+		t.Fatal(err)
+	}
+	_ = aged.publish(context.Background(), transport, "t", 0, false, payload)
+	clock.now = clock.now.Add(time.Millisecond * 20)
+	_ = aged.publish(context.Background(), transport, "t", 0, false, payload)
+	if stats := aged.Stats(); 1 != stats.Pending || 1 != stats.Dropped {
+		t.Error("Expire not match, was: ", stats)
+	}
+}
+
+func TestOfflineQueueTopicLimit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edgex-offline")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue, err := openOfflineQueue(&Globals{OfflineQueueDir: dir}, SystemClock())
+	if nil != err {
+		t.Fatal(err)
+	}
+	transport := NewMemoryBroker().Transport("PUB", nil)
+	topic := strings.Repeat("t", math.MaxUint16+1)
+	if err := queue.publish(context.Background(), transport, topic, 0, false, []byte("A")); nil == err {
+		t.Error("Should reject topic over length limit")
+	}
+	if stats := queue.Stats(); 0 != stats.Pending {
+		t.Error("Topic over length limit should not be queued, was: ", stats)
+	}
+}
+
+func TestOfflineQueueContextCancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "edgex-offline")
+	if nil != err {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	queue, err := openOfflineQueue(&Globals{OfflineQueueDir: dir}, SystemClock())
+	if nil != err {
+		t.Fatal(err)
+	}
+	transport := &blockingTransport{Transport: NewMemoryBroker().Transport("PUB", nil)}
+	_ = transport.Connect()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	if err := queue.publish(ctx, transport, "t", 0, false, []byte("A")); context.DeadlineExceeded != err {
+		t.Error("Should return ctx error, was: ", err)
+	}
+	if stats := queue.Stats(); 0 != stats.Pending || 0 != stats.Queued {
+		t.Error("Cancelled message should not be queued, was: ", stats)
+	}
+}
+
+// blockingTransport 发布操作阻塞到ctx结束的Transport
+type blockingTransport struct {
+	Transport
+}
+
+func (b *blockingTransport) Publish(ctx context.Context, topic string, qos uint8, retained bool, payload []byte) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// steppedClock 由测试代码设置当前时间的时钟
+type steppedClock struct {
+	now time.Time
+}
+
+func (c *steppedClock) Now() time.Time {
+	return c.now
+}
+
+func (c *steppedClock) NewTicker(d time.Duration) Ticker {
+	return SystemClock().NewTicker(d)
+}
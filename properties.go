@@ -43,3 +43,18 @@ type VirtualNodeState struct {
 	State   string                 `json:"state"`   // 设备状态
 	Values  map[string]interface{} `json:"values"`  // 设备状态数值
 }
+
+const (
+	NodeStateAlive   = "ALIVE"
+	NodeStateOffline = "OFFLINE"
+)
+
+// 主节点状态模型，Context按心跳周期发送到节点的States主题
+type MainNodeState struct {
+	NodeId    string `json:"nodeId"`    // 节点ID
+	State     string `json:"state"`     // 节点状态：ALIVE / OFFLINE
+	Uptime    int64  `json:"uptime"`    // 运行时长，秒
+	Version   string `json:"version"`   // 节点程序版本
+	LastError string `json:"lastError"` // 最近一次错误信息
+	Heartbeat int64  `json:"heartbeat"` // 心跳周期，毫秒；为0时节点不发送心跳
+}
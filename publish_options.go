@@ -0,0 +1,80 @@
+package edgex
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+// PublishOptions 消息发送参数
+type PublishOptions struct {
+	QoS      uint8         // MQTT QoS，取值为0~2
+	Retained bool          // 是否为保留消息
+	Timeout  time.Duration // 发送超时时间，为0时使用 Globals.MqttPublishTimeout
+}
+
+// 单次发送的参数选项，覆盖 TriggerOptions 中按消息类别设置的默认参数
+type PublishOption func(opts *PublishOptions)
+
+// WithQoS 指定消息发送的QoS
+func WithQoS(qos uint8) PublishOption {
+	if qos > 2 {
+		log.Panicf("QoS取值范围为0~2，当前：%d", qos)
+	}
+	return func(opts *PublishOptions) {
+		opts.QoS = qos
+	}
+}
+
+// WithRetained 指定是否为保留消息
+func WithRetained(retained bool) PublishOption {
+	return func(opts *PublishOptions) {
+		opts.Retained = retained
+	}
+}
+
+// WithPublishTimeout 指定消息发送超时时间
+func WithPublishTimeout(timeout time.Duration) PublishOption {
+	if timeout < 0 {
+		log.Panicf("发送超时时间不能小于0，当前：%s", timeout)
+	}
+	return func(opts *PublishOptions) {
+		opts.Timeout = timeout
+	}
+}
+
+// verifyPublishOptions 检查发送参数：QoS取值为0~2，超时时间不小于0
+func verifyPublishOptions(options PublishOptions) error {
+	if options.QoS > 2 {
+		return fmt.Errorf("invalid publish options: qos %d out of range 0~2", options.QoS)
+	}
+	if options.Timeout < 0 {
+		return fmt.Errorf("invalid publish options: negative timeout %s", options.Timeout)
+	}
+	return nil
+}
+
+// newPublishOptions 合并发送参数：Globals的QoS/Retained设置 < 消息类别的默认参数 < 单次发送参数。
+// 合并结果无效时返回错误，消息不会被发送。
+func newPublishOptions(globals *Globals, defaults *PublishOptions, opts []PublishOption) (PublishOptions, error) {
+	options := PublishOptions{QoS: globals.MqttQoS, Retained: globals.MqttRetained}
+	if nil != defaults {
+		options = *defaults
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options, verifyPublishOptions(options)
+}
+
+// withPublishTimeout 按发送参数的超时时间创建ctx；未设置超时时间时返回原ctx
+func withPublishTimeout(ctx context.Context, options PublishOptions) (context.Context, context.CancelFunc) {
+	if 0 < options.Timeout {
+		return context.WithTimeout(ctx, options.Timeout)
+	}
+	return ctx, func() {}
+}
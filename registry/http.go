@@ -0,0 +1,55 @@
+package registry
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+const (
+	pathNodes = "/nodes"
+)
+
+// errorResponse HTTP错误响应
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// ServeHTTP 提供只读的HTTP/JSON查询接口：
+//
+//	GET /nodes            全部节点列表
+//	GET /nodes/{nodeId}   指定节点
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if http.MethodGet != req.Method {
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "method not allowed"})
+		return
+	}
+	path := strings.TrimSuffix(req.URL.Path, "/")
+	switch {
+	case pathNodes == path:
+		writeJSON(w, http.StatusOK, r.Nodes())
+
+	case strings.HasPrefix(path, pathNodes+"/"):
+		nodeId := strings.TrimPrefix(path, pathNodes+"/")
+		if node, ok := r.Node(nodeId); ok {
+			writeJSON(w, http.StatusOK, node)
+		} else {
+			writeJSON(w, http.StatusNotFound, errorResponse{Error: "node not found: " + nodeId})
+		}
+
+	default:
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: "not found"})
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(data); nil != err {
+		log.Error("返回HTTP响应出错：", err)
+	}
+}
@@ -0,0 +1,281 @@
+// Package registry 汇总EdgeX节点发布的Properties及State消息，维护各节点及虚拟节点的内存模型，
+// 并通过HTTP/JSON接口提供查询。
+package registry
+
+import (
+	"encoding/json"
+	"github.com/nextabc-lab/edgex-go"
+	"sort"
+	"sync"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+const (
+	DefaultHeartbeatMisses = 3
+	DefaultEvictAfter      = time.Hour * 24
+)
+
+var log = edgex.ZapSugarLogger
+
+// Options 注册中心选项
+type Options struct {
+	// 超过此时长未收到节点消息时，判定节点离线；为0时按节点心跳周期乘以HeartbeatMisses计算，
+	// 节点未发送心跳时，仅在接收到OFFLINE状态（包括遗嘱消息）时判定离线。
+	NodeTimeout time.Duration
+	// 允许连续丢失的心跳次数，为0时使用默认值 DefaultHeartbeatMisses
+	HeartbeatMisses int
+	// 节点离线且超过此时长未收到消息时，从注册中心移除；为0时使用默认值 DefaultEvictAfter，小于0时不移除
+	EvictAfter time.Duration
+	// 时钟，为nil时使用系统时钟
+	Clock edgex.Clock
+}
+
+// Node 节点模型
+type Node struct {
+	NodeId       string                    `json:"nodeId"`
+	Online       bool                      `json:"online"`
+	LastSeen     time.Time                 `json:"lastSeen"`
+	State        *edgex.MainNodeState      `json:"state,omitempty"`
+	Properties   *edgex.MainNodeProperties `json:"properties,omitempty"`
+	VirtualNodes []VirtualNode             `json:"virtualNodes"`
+}
+
+// VirtualNode 虚拟节点模型
+type VirtualNode struct {
+	UnionId    string                       `json:"unionId"`
+	LastSeen   time.Time                    `json:"lastSeen"`
+	Properties *edgex.VirtualNodeProperties `json:"properties,omitempty"`
+	State      *edgex.VirtualNodeState      `json:"state,omitempty"`
+}
+
+type nodeEntry struct {
+	state      *edgex.MainNodeState
+	properties *edgex.MainNodeProperties
+	virtual    map[string]*VirtualNode
+	lastSeen   time.Time
+	offline    bool
+}
+
+// Registry 节点注册中心
+type Registry struct {
+	opts      Options
+	mutex     sync.RWMutex
+	nodes     map[string]*nodeEntry
+	lastEvict time.Time
+}
+
+// New 创建注册中心
+func New(opts Options) *Registry {
+	if 0 >= opts.HeartbeatMisses {
+		opts.HeartbeatMisses = DefaultHeartbeatMisses
+	}
+	if 0 == opts.EvictAfter {
+		opts.EvictAfter = DefaultEvictAfter
+	}
+	if nil == opts.Clock {
+		opts.Clock = edgex.SystemClock()
+	}
+	return &Registry{
+		opts:  opts,
+		nodes: make(map[string]*nodeEntry),
+	}
+}
+
+// Attach 订阅全部节点的Properties及State消息，并请求全部节点重新上报Properties。
+func (r *Registry) Attach(ctx edgex.Context) error {
+	if err := ctx.SubscribeProperties("+", r.HandleProperties); nil != err {
+		return err
+	}
+	if err := ctx.SubscribeStates("#", r.HandleState); nil != err {
+		return err
+	}
+	return ctx.RequestDiscovery("")
+}
+
+// HandleProperties 处理节点的Properties消息。
+// Properties消息包含节点的全部虚拟节点，不在其中的虚拟节点被移除。
+func (r *Registry) HandleProperties(msg edgex.Message) {
+	properties := new(edgex.MainNodeProperties)
+	if err := json.Unmarshal(msg.Body(), properties); nil != err {
+		log.Errorf("解析Properties消息出错，节点：%s, 错误：%s", msg.NodeId(), err)
+		return
+	}
+	if "" == properties.NodeId {
+		properties.NodeId = msg.NodeId()
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := r.opts.Clock.Now()
+	node := r.touch(properties.NodeId, now)
+	node.properties = properties
+	virtual := make(map[string]*VirtualNode, len(properties.VirtualNodes))
+	for _, vp := range properties.VirtualNodes {
+		unionId := vp.UnionId
+		if "" == unionId {
+			unionId = edgex.MakeUnionId(properties.NodeId, vp.BoardId, vp.MajorId, vp.MinorId)
+		}
+		vn := node.virtualNode(unionId)
+		vn.Properties = vp
+		vn.LastSeen = now
+		virtual[unionId] = vn
+	}
+	node.virtual = virtual
+}
+
+// HandleState 处理节点States主题的主节点状态及虚拟节点状态消息
+func (r *Registry) HandleState(msg edgex.Message) {
+	if state, err := edgex.ParseMainNodeState(msg); edgex.ErrNotMainNodeState != err {
+		if nil != err {
+			log.Errorf("解析节点状态消息出错，节点：%s, 错误：%s", msg.NodeId(), err)
+			return
+		}
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		node := r.touch(msg.NodeId(), r.opts.Clock.Now())
+		node.state = &state
+		node.offline = edgex.NodeStateOffline == state.State
+		return
+	}
+	state := new(edgex.VirtualNodeState)
+	if err := json.Unmarshal(msg.Body(), state); nil != err {
+		log.Errorf("解析虚拟节点状态消息出错，节点：%s, 错误：%s", msg.UnionId(), err)
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := r.opts.Clock.Now()
+	vn := r.touch(msg.NodeId(), now).virtualNode(msg.UnionId())
+	vn.State = state
+	vn.LastSeen = now
+}
+
+// Nodes 返回按节点ID排序的全部节点，不包括已过期待移除的节点
+func (r *Registry) Nodes() []Node {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	now := r.opts.Clock.Now()
+	nodes := make([]Node, 0, len(r.nodes))
+	for nodeId, entry := range r.nodes {
+		if !r.expired(entry, now) {
+			nodes = append(nodes, r.snapshot(nodeId, entry, now))
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].NodeId < nodes[j].NodeId
+	})
+	return nodes
+}
+
+// Node 返回指定节点，节点不存在时返回false
+func (r *Registry) Node(nodeId string) (Node, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	now := r.opts.Clock.Now()
+	entry, ok := r.nodes[nodeId]
+	if !ok || r.expired(entry, now) {
+		return Node{}, false
+	}
+	return r.snapshot(nodeId, entry, now), true
+}
+
+// Remove 移除指定节点，节点不存在时返回false
+func (r *Registry) Remove(nodeId string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	_, ok := r.nodes[nodeId]
+	delete(r.nodes, nodeId)
+	return ok
+}
+
+// Evict 移除离线且超过 Options.EvictAfter 时长未收到消息的节点，返回移除的节点数量。
+// 接收到消息时按EvictAfter周期自动执行。
+func (r *Registry) Evict() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.evict(r.opts.Clock.Now())
+}
+
+// evict 须在持有写锁时调用
+func (r *Registry) evict(now time.Time) int {
+	r.lastEvict = now
+	count := 0
+	for nodeId, entry := range r.nodes {
+		if r.expired(entry, now) {
+			delete(r.nodes, nodeId)
+			count++
+		}
+	}
+	return count
+}
+
+// touch 返回节点记录，并更新最后接收消息时间。须在持有写锁时调用。
+// 节点离线标记仅由主节点状态消息更新：订阅时接收到的保留消息顺序不确定，Properties等保留消息不能说明节点在线。
+func (r *Registry) touch(nodeId string, now time.Time) *nodeEntry {
+	if 0 < r.opts.EvictAfter && now.Sub(r.lastEvict) >= r.opts.EvictAfter {
+		r.evict(now)
+	}
+	node, ok := r.nodes[nodeId]
+	if !ok {
+		node = &nodeEntry{virtual: make(map[string]*VirtualNode)}
+		r.nodes[nodeId] = node
+	}
+	node.lastSeen = now
+	return node
+}
+
+// snapshot 复制节点模型，并计算在线状态。须在持有读锁时调用。
+func (r *Registry) snapshot(nodeId string, entry *nodeEntry, now time.Time) Node {
+	node := Node{
+		NodeId:       nodeId,
+		Online:       r.online(entry, now),
+		LastSeen:     entry.lastSeen,
+		State:        entry.state,
+		Properties:   entry.properties,
+		VirtualNodes: make([]VirtualNode, 0, len(entry.virtual)),
+	}
+	for _, vn := range entry.virtual {
+		node.VirtualNodes = append(node.VirtualNodes, *vn)
+	}
+	sort.Slice(node.VirtualNodes, func(i, j int) bool {
+		return node.VirtualNodes[i].UnionId < node.VirtualNodes[j].UnionId
+	})
+	return node
+}
+
+// online 判断节点是否在线：未接收到OFFLINE状态，且未超过离线超时时长
+func (r *Registry) online(entry *nodeEntry, now time.Time) bool {
+	if entry.offline {
+		return false
+	}
+	timeout := r.timeout(entry)
+	return 0 >= timeout || now.Sub(entry.lastSeen) <= timeout
+}
+
+// expired 判断节点是否已离线且超过EvictAfter时长未收到消息
+func (r *Registry) expired(entry *nodeEntry, now time.Time) bool {
+	return 0 < r.opts.EvictAfter && !r.online(entry, now) && now.Sub(entry.lastSeen) > r.opts.EvictAfter
+}
+
+// timeout 返回判定节点离线的超时时长；为0时不按超时判定
+func (r *Registry) timeout(entry *nodeEntry) time.Duration {
+	if 0 < r.opts.NodeTimeout {
+		return r.opts.NodeTimeout
+	}
+	if nil != entry.state && 0 < entry.state.Heartbeat {
+		return time.Duration(entry.state.Heartbeat) * time.Millisecond * time.Duration(r.opts.HeartbeatMisses)
+	}
+	return 0
+}
+
+func (n *nodeEntry) virtualNode(unionId string) *VirtualNode {
+	vn, ok := n.virtual[unionId]
+	if !ok {
+		vn = &VirtualNode{UnionId: unionId}
+		n.virtual[unionId] = vn
+	}
+	return vn
+}
@@ -0,0 +1,148 @@
+package registry
+
+import (
+	"encoding/json"
+	"github.com/nextabc-lab/edgex-go"
+	"github.com/nextabc-lab/edgex-go/edgextest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func awaitNode(t *testing.T, reg *Registry, nodeId string, check func(node Node) bool) Node {
+	t.Helper()
+	deadline := time.Now().Add(time.Second * 2)
+	for time.Now().Before(deadline) {
+		if node, ok := reg.Node(nodeId); ok && check(node) {
+			return node
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	node, _ := reg.Node(nodeId)
+	t.Fatalf("Node not match, was: %+v", node)
+	return node
+}
+
+func TestRegistry(t *testing.T) {
+	h := edgextest.New(t, "REGISTRY")
+	defer h.Close()
+	node := h.NewContext("NODE", &edgex.Globals{NodeStateRetained: true})
+	trigger := node.NewTrigger(edgex.TriggerOptions{
+		Topic: "example/registry",
+		NodePropertiesFunc: func() edgex.MainNodeProperties {
+			return edgex.MainNodeProperties{
+				NodeType:     edgex.NodeTypeTrigger,
+				VirtualNodes: []*edgex.VirtualNodeProperties{{BoardId: "main", MajorId: "A"}},
+			}
+		},
+	})
+	trigger.Startup()
+	defer trigger.Shutdown()
+	trigger.PublishNodeState(edgex.VirtualNodeState{BoardId: "main", MajorId: "A", State: "ON"})
+
+	// 注册中心后启动，通过保留消息及Discovery请求获取节点信息
+	reg := New(Options{})
+	if err := reg.Attach(h.Context); nil != err {
+		t.Fatal("Attach failed: ", err)
+	}
+	found := awaitNode(t, reg, "NODE", func(node Node) bool {
+		return node.Online && nil != node.State && nil != node.Properties &&
+			1 == len(node.VirtualNodes) && nil != node.VirtualNodes[0].State
+	})
+	if vn := found.VirtualNodes[0]; "ON" != vn.State.State || nil == vn.Properties {
+		t.Errorf("Virtual node not match, was: %+v", vn)
+	}
+
+	// HTTP接口
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	resp, err := http.Get(server.URL + "/nodes")
+	if nil != err {
+		t.Fatal(err)
+	}
+	nodes := make([]Node, 0)
+	if err := json.NewDecoder(resp.Body).Decode(&nodes); nil != err || 0 == len(nodes) || "NODE" != nodes[0].NodeId {
+		t.Errorf("Nodes not match, was: %+v, %v", nodes, err)
+	}
+	resp.Body.Close()
+	resp, err = http.Get(server.URL + "/nodes/UNKNOWN")
+	if nil != err {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if http.StatusNotFound != resp.StatusCode {
+		t.Errorf("Unknown node should return 404, was: %d", resp.StatusCode)
+	}
+
+	// 连接异常断开时，由遗嘱消息标记离线
+	h.Broker.Kick(edgex.MqttClientIdHeader + ":NODE")
+	awaitNode(t, reg, "NODE", func(node Node) bool {
+		return !node.Online
+	})
+}
+
+func TestRegistryHeartbeatTimeout(t *testing.T) {
+	h := edgextest.New(t, "REGISTRY")
+	defer h.Close()
+	// 节点使用Harness时钟，时钟不推进时不发送心跳
+	h.NewContext("NODE", &edgex.Globals{NodeStateRetained: true, HeartbeatInterval: time.Millisecond * 20})
+	clock := edgextest.NewFakeClock(time.Now())
+	reg := New(Options{Clock: clock})
+	if err := reg.Attach(h.Context); nil != err {
+		t.Fatal("Attach failed: ", err)
+	}
+	awaitNode(t, reg, "NODE", func(node Node) bool {
+		return node.Online && nil != node.State && 0 < node.State.Heartbeat
+	})
+	// 超过心跳周期×HeartbeatMisses未收到消息后判定离线
+	clock.Advance(time.Millisecond * 20 * DefaultHeartbeatMisses * 2)
+	if node, _ := reg.Node("NODE"); node.Online {
+		t.Errorf("Node should be offline after heartbeat timeout, was: %+v", node)
+	}
+}
+
+func TestRegistryEvict(t *testing.T) {
+	clock := edgextest.NewFakeClock(time.Now())
+	reg := New(Options{Clock: clock, EvictAfter: time.Minute})
+	properties := func(nodeId string, majorIds ...string) edgex.Message {
+		vns := make([]*edgex.VirtualNodeProperties, 0)
+		for _, majorId := range majorIds {
+			vns = append(vns, &edgex.VirtualNodeProperties{BoardId: "main", MajorId: majorId})
+		}
+		body, _ := json.Marshal(edgex.MainNodeProperties{NodeId: nodeId, NodeType: edgex.NodeTypeTrigger, VirtualNodes: vns})
+		return edgex.NewMessage(nodeId, nodeId, nodeId, "", body, 0)
+	}
+	state := func(nodeId, state string) edgex.Message {
+		body, _ := json.Marshal(edgex.MainNodeState{NodeId: nodeId, State: state})
+		return edgex.NewMessage(nodeId, nodeId, nodeId, "", body, 0)
+	}
+
+	// 不在Properties中的虚拟节点被移除
+	reg.HandleProperties(properties("A", "1", "2"))
+	reg.HandleProperties(properties("A", "2"))
+	if node, _ := reg.Node("A"); 1 != len(node.VirtualNodes) || "A:main:2:" != node.VirtualNodes[0].UnionId {
+		t.Errorf("Virtual nodes not match, was: %+v", node.VirtualNodes)
+	}
+
+	// 离线超过EvictAfter的节点被移除，在线节点保留
+	reg.HandleState(state("A", edgex.NodeStateOffline))
+	reg.HandleState(state("B", edgex.NodeStateAlive))
+	clock.Advance(time.Minute * 2)
+	if _, ok := reg.Node("A"); ok {
+		t.Error("Expired node should not be visible")
+	}
+	if n := reg.Evict(); 1 != n {
+		t.Error("Evict count not match, was: ", n)
+	}
+	if nodes := reg.Nodes(); 1 != len(nodes) || "B" != nodes[0].NodeId {
+		t.Errorf("Nodes not match, was: %+v", nodes)
+	}
+	if !reg.Remove("B") || reg.Remove("B") {
+		t.Error("Remove not match")
+	}
+}
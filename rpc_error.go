@@ -0,0 +1,74 @@
+package edgex
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+// RPC错误码
+const (
+	RpcErrBadRequest     = 400 // 请求格式错误
+	RpcErrNoSuchNode     = 404 // 虚拟节点不存在
+	RpcErrUnknownCommand = 405 // 虚拟节点未声明的命令
+	RpcErrBusy           = 429 // Endpoint请求等待队列已满
+	RpcErrInternal       = 500 // Endpoint处理出错
+	RpcErrNotImplemented = 501 // Endpoint未设置处理函数
+	RpcErrUnavailable    = 503 // Endpoint已关闭
+	RpcErrTimeout        = 504 // 超过调用方指定的消息有效时长
+)
+
+// RpcError 是Endpoint通过错误响应帧(FrameVarErrorReply)返回的结构化错误。
+type RpcError struct {
+	Code    int    `json:"code"`    // 错误码
+	Message string `json:"message"` // 错误描述
+}
+
+func (e *RpcError) Error() string {
+	return fmt.Sprintf("rpc error(%d): %s", e.Code, e.Message)
+}
+
+// Bytes 返回错误的JSON数据
+func (e *RpcError) Bytes() []byte {
+	data, err := json.Marshal(e)
+	if nil != err {
+		log.Panic("数据序列化错误", err)
+	}
+	return data
+}
+
+// NewRpcError 创建RpcError对象
+func NewRpcError(code int, message string) *RpcError {
+	return &RpcError{
+		Code:    code,
+		Message: message,
+	}
+}
+
+// toRpcError 将处理函数返回的错误转换为RpcError
+func toRpcError(err error) *RpcError {
+	switch err {
+	case context.DeadlineExceeded:
+		return NewRpcError(RpcErrTimeout, err.Error())
+
+	case context.Canceled:
+		return NewRpcError(RpcErrUnavailable, err.Error())
+	}
+	if rpcErr, ok := err.(*RpcError); ok {
+		return rpcErr
+	}
+	return NewRpcError(RpcErrInternal, err.Error())
+}
+
+// ParseRpcError 解析错误响应帧的消息体。消息体不是JSON格式时，作为错误描述返回。
+func ParseRpcError(body []byte) *RpcError {
+	rpcErr := new(RpcError)
+	if err := json.Unmarshal(body, rpcErr); nil != err {
+		return NewRpcError(RpcErrInternal, string(body))
+	}
+	return rpcErr
+}
@@ -0,0 +1,57 @@
+package edgex
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func TestRpcError(t *testing.T) {
+	cases := []struct {
+		err  error
+		code int
+	}{
+		{context.DeadlineExceeded, RpcErrTimeout},
+		{context.Canceled, RpcErrUnavailable},
+		{NewRpcError(RpcErrNoSuchNode, "no such node"), RpcErrNoSuchNode},
+		{errors.New("failure"), RpcErrInternal},
+	}
+	for _, c := range cases {
+		if rpcErr := toRpcError(c.err); c.code != rpcErr.Code {
+			t.Errorf("Code not match, error: %s, expected: %d, was: %d", c.err, c.code, rpcErr.Code)
+		}
+	}
+
+	// Encode/Parse
+	parsed := ParseRpcError(NewRpcError(RpcErrBusy, "busy").Bytes())
+	if RpcErrBusy != parsed.Code || "busy" != parsed.Message {
+		t.Error("Parsed error not match, was: ", parsed)
+	}
+	if parsed := ParseRpcError([]byte("plain text")); RpcErrInternal != parsed.Code || "plain text" != parsed.Message {
+		t.Error("Plain error not match, was: ", parsed)
+	}
+}
+
+func TestDriverErrorReply(t *testing.T) {
+	broker := NewMemoryBroker()
+	responder := startResponder(t, broker, "ENDPOINT", func(req Message) []Message {
+		return []Message{NewMessageByUnionId(req.UnionId(), NewRpcError(RpcErrNotImplemented, "not implemented").Bytes(),
+			req.EventId(), WithControlVar(FrameVarErrorReply))}
+	})
+	defer responder.Disconnect(0)
+
+	driverCtx := newLoopbackContext(broker, "DRIVER")
+	defer driverCtx.destroy()
+	driver := driverCtx.NewDriver(DriverOptions{})
+	driver.Startup()
+	defer driver.Shutdown()
+
+	_, err := driver.Call(context.Background(), "ENDPOINT", driver.NewRequest("ENDPOINT", "main", "A", "", nil))
+	if rpcErr, ok := err.(*RpcError); !ok || RpcErrNotImplemented != rpcErr.Code || "not implemented" != rpcErr.Message {
+		t.Error("Error reply not match, was: ", err)
+	}
+}
@@ -17,8 +17,18 @@ const (
 	prefixStatistics = "$EdgeX/statistics/"
 	prefixRequests   = "$EdgeX/requests/"
 	prefixReplies    = "$EdgeX/replies/"
+	topicDiscovery   = "$EdgeX/discovery"
 )
 
+// TopicOfDiscovery 返回请求节点重新上报Properties消息的主题；nodeId为空时为请求全部节点的主题。
+func TopicOfDiscovery(nodeId string) string {
+	if "" == nodeId {
+		return topicDiscovery
+	}
+	checkTopicAllowed(nodeId)
+	return topicDiscovery + "/" + nodeId
+}
+
 func TopicOfEvents(exTopic string) string {
 	checkTopicAllowed(exTopic)
 	return prefixEvents + exTopic
@@ -34,6 +44,14 @@ func TopicOfStates(nodeId string) string {
 	return prefixStates + nodeId
 }
 
+// TopicOfVirtualStates 返回虚拟节点的State主题：States主题/UnionId。
+// 每个虚拟节点使用独立的主题，以便Broker为每个虚拟节点保留最后的状态消息。
+// 不兼容变更：虚拟节点状态不再发送到 TopicOfStates(nodeId) 主题，直接订阅MQTT主题的消费方须改为订阅 TopicOfStates(nodeId)+"/#"；
+// SubscribeStates 已同时订阅主节点及虚拟节点状态。
+func TopicOfVirtualStates(unionId string) string {
+	return TopicOfStates(splitUnionId(unionId)[0]) + "/" + unionId
+}
+
 func TopicOfActions(nodeId string) string {
 	checkTopicAllowed(nodeId)
 	return prefixActions + nodeId
@@ -54,11 +72,27 @@ func topicOfRequestListen(callerNodeId string) string {
 	return prefixRequests + callerNodeId + "/+"
 }
 
+func topicOfRequestSend(executorNodeId, callerNodeId string) string {
+	// prefix / ExecutorNodeId / CallerNodeId
+	checkTopicAllowed(executorNodeId)
+	return prefixRequests + executorNodeId + "/" + callerNodeId
+}
+
 func topicOfRepliesSend(executorNodeId, callerNodeId string) string {
 	// prefix / CallerNodeId / ExecutorNodeId
 	return prefixReplies + callerNodeId + "/" + executorNodeId
 }
 
+func topicOfRepliesListen(callerNodeId string) string {
+	return prefixReplies + callerNodeId + "/+"
+}
+
+func topicToRepliesExecutor(exTopic string) string {
+	// prefix / CallerNodeId / ExecutorNodeId
+	idx := strings.LastIndex(exTopic, "/")
+	return exTopic[idx+1:]
+}
+
 func checkTopicAllowed(topic string) {
 	if strings.HasPrefix(topic, "/") {
 		log.Panicf("Topic MUST NOT starts with '/', was: %s", topic)
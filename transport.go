@@ -0,0 +1,81 @@
+package edgex
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+var (
+	ErrTransportNotConnected = errors.New("transport not connected")
+)
+
+// 订阅消息处理函数
+type TransportHandler func(topic string, payload []byte)
+
+// Transport 是消息传输层接口。Context及Trigger/Endpoint/Driver组件通过Transport发布和订阅消息，
+// 默认使用基于Paho的MQTT实现；测试及单进程部署可使用 MemoryBroker 提供的内存实现。
+type Transport interface {
+	// SetWill 设置连接异常断开时发送的遗嘱消息，须在Connect之前调用。
+	SetWill(topic string, payload []byte, qos uint8, retained bool)
+
+	// OnConnected 设置连接成功（包括自动重连成功）后的回调函数，须在Connect之前调用。
+	OnConnected(handler func())
+
+	// Connect 连接到消息服务，失败时返回错误
+	Connect() error
+
+	// Disconnect 断开连接。quiesceMillSec为等待未完成操作的毫秒数。
+	Disconnect(quiesceMillSec uint)
+
+	// IsConnected 返回是否已连接
+	IsConnected() bool
+
+	// CurrentBroker 返回当前已连接的Broker地址，未连接时返回空字符串
+	CurrentBroker() string
+
+	// Publish 发布消息，阻塞等待发送完成；ctx被取消或超时时返回ctx的错误。
+	// ctx未设置Deadline时，MQTT实现使用 Globals.MqttPublishTimeout 作为超时时间。
+	Publish(ctx context.Context, topic string, qos uint8, retained bool, payload []byte) error
+
+	// Subscribe 订阅Topic，支持MQTT通配符 '+' 和 '#'
+	Subscribe(topic string, qos uint8, handler TransportHandler) error
+
+	// Unsubscribe 取消订阅
+	Unsubscribe(topics ...string) error
+}
+
+// TransportFactory 根据节点的ClientId及全局配置，创建Transport对象
+type TransportFactory func(clientId string, globals *Globals) Transport
+
+// ContextOption Context创建选项
+type ContextOption func(c *NodeContext)
+
+// WithTransport 指定Context使用的Transport。默认使用 NewMqttTransport 创建MQTT实现。
+func WithTransport(factory TransportFactory) ContextOption {
+	return func(c *NodeContext) {
+		c.transportFactory = factory
+	}
+}
+
+// topicMatch 判断Topic是否匹配订阅的TopicFilter，支持MQTT通配符 '+' 和 '#'
+func topicMatch(filter, topic string) bool {
+	fs := strings.Split(filter, "/")
+	ts := strings.Split(topic, "/")
+	for i, f := range fs {
+		if "#" == f {
+			return true
+		}
+		if i >= len(ts) {
+			return false
+		}
+		if "+" != f && f != ts[i] {
+			return false
+		}
+	}
+	return len(fs) == len(ts)
+}
@@ -0,0 +1,316 @@
+package edgex
+
+import (
+	"context"
+	"sync"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+const (
+	MemoryBrokerURL = "memory://loopback"
+)
+
+// MemoryBroker 是进程内的Loopback消息Broker，为测试及单进程部署提供内存Transport实现。
+// 支持MQTT通配符 '+' 和 '#'、Retained消息及遗嘱消息。
+// 订阅消息按客户端顺序投递，每个客户端使用独立协程调用处理函数。
+// 使用 CreateDefaultContext(WithTransport(broker.Transport)) 创建基于此Broker的Context。
+type MemoryBroker struct {
+	mutex    sync.RWMutex
+	clients  map[*memoryTransport]struct{}
+	retained map[string][]byte
+}
+
+// NewMemoryBroker 创建进程内Broker
+func NewMemoryBroker() *MemoryBroker {
+	return &MemoryBroker{
+		clients:  make(map[*memoryTransport]struct{}),
+		retained: make(map[string][]byte),
+	}
+}
+
+// Kick 模拟指定客户端的连接异常断开，并发送该客户端的遗嘱消息。返回客户端是否存在。
+func (b *MemoryBroker) Kick(clientId string) bool {
+	b.mutex.RLock()
+	var found *memoryTransport
+	for client := range b.clients {
+		if clientId == client.clientId {
+			found = client
+			break
+		}
+	}
+	b.mutex.RUnlock()
+	if nil == found {
+		return false
+	}
+	found.Disconnect(0)
+	if will := found.will; nil != will {
+		b.publish(will.topic, will.payload, will.retained)
+	}
+	return true
+}
+
+// Retained 返回指定Topic的Retained消息
+func (b *MemoryBroker) Retained(topic string) (payload []byte, ok bool) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	payload, ok = b.retained[topic]
+	return payload, ok
+}
+
+// Transport 创建连接到此Broker的Transport对象，可作为 TransportFactory 使用。
+func (b *MemoryBroker) Transport(clientId string, globals *Globals) Transport {
+	return &memoryTransport{
+		broker:   b,
+		clientId: clientId,
+		subs:     make(map[string]TransportHandler),
+	}
+}
+
+func (b *MemoryBroker) attach(client *memoryTransport) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.clients[client] = struct{}{}
+}
+
+// matchRetained 返回匹配TopicFilter的Retained消息
+func (b *MemoryBroker) matchRetained(filter string) map[string][]byte {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	matched := make(map[string][]byte)
+	for topic, payload := range b.retained {
+		if topicMatch(filter, topic) {
+			matched[topic] = payload
+		}
+	}
+	return matched
+}
+
+func (b *MemoryBroker) detach(client *memoryTransport) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.clients, client)
+}
+
+// publish 投递消息到全部客户端。Retained消息的Payload为空时，清除该Topic的Retained消息。
+func (b *MemoryBroker) publish(topic string, payload []byte, retained bool) {
+	b.mutex.Lock()
+	if retained {
+		if 0 == len(payload) {
+			delete(b.retained, topic)
+		} else {
+			b.retained[topic] = payload
+		}
+	}
+	clients := make([]*memoryTransport, 0, len(b.clients))
+	for client := range b.clients {
+		clients = append(clients, client)
+	}
+	b.mutex.Unlock()
+	for _, client := range clients {
+		client.deliver(topic, payload)
+	}
+}
+
+////
+
+type memoryWill struct {
+	topic    string
+	payload  []byte
+	retained bool
+}
+
+type memoryDelivery struct {
+	handler TransportHandler
+	topic   string
+	payload []byte
+}
+
+// memoryInbox 是单次连接的无界投递队列。处理函数可以向自身订阅的Topic发布消息，不会因队列已满而阻塞。
+type memoryInbox struct {
+	mutex  sync.Mutex
+	queue  []*memoryDelivery
+	notify chan struct{}
+	done   chan struct{}
+}
+
+func newMemoryInbox() *memoryInbox {
+	return &memoryInbox{
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (i *memoryInbox) put(deliveries ...*memoryDelivery) {
+	if 0 == len(deliveries) {
+		return
+	}
+	i.mutex.Lock()
+	i.queue = append(i.queue, deliveries...)
+	i.mutex.Unlock()
+	select {
+	case i.notify <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch 按顺序调用处理函数，直到连接断开
+func (i *memoryInbox) dispatch() {
+	for {
+		i.mutex.Lock()
+		queue := i.queue
+		i.queue = nil
+		i.mutex.Unlock()
+		if 0 == len(queue) {
+			select {
+			case <-i.notify:
+				continue
+			case <-i.done:
+				return
+			}
+		}
+		for _, d := range queue {
+			select {
+			case <-i.done:
+				return
+			default:
+			}
+			d.handler(d.topic, d.payload)
+		}
+	}
+}
+
+type memoryTransport struct {
+	broker      *MemoryBroker
+	clientId    string
+	will        *memoryWill
+	onConnected func()
+	mutex       sync.RWMutex
+	connected   bool
+	subs        map[string]TransportHandler
+	inbox       *memoryInbox
+}
+
+func (m *memoryTransport) SetWill(topic string, payload []byte, qos uint8, retained bool) {
+	m.will = &memoryWill{
+		topic:    topic,
+		payload:  append([]byte{}, payload...),
+		retained: retained,
+	}
+}
+
+func (m *memoryTransport) OnConnected(handler func()) {
+	m.onConnected = handler
+}
+
+// Connect 连接Broker，并为已记录的订阅投递匹配的Retained消息
+func (m *memoryTransport) Connect() error {
+	m.mutex.Lock()
+	if m.connected {
+		m.mutex.Unlock()
+		return nil
+	}
+	m.connected = true
+	m.inbox = newMemoryInbox()
+	inbox := m.inbox
+	subs := make(map[string]TransportHandler, len(m.subs))
+	for topic, handler := range m.subs {
+		subs[topic] = handler
+	}
+	m.mutex.Unlock()
+	go inbox.dispatch()
+	m.broker.attach(m)
+	for topic, handler := range subs {
+		inbox.put(m.retainedDeliveries(topic, handler)...)
+	}
+	if nil != m.onConnected {
+		m.onConnected()
+	}
+	return nil
+}
+
+func (m *memoryTransport) Disconnect(quiesceMillSec uint) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if !m.connected {
+		return
+	}
+	m.broker.detach(m)
+	m.connected = false
+	close(m.inbox.done)
+}
+
+func (m *memoryTransport) IsConnected() bool {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.connected
+}
+
+func (m *memoryTransport) CurrentBroker() string {
+	if m.IsConnected() {
+		return MemoryBrokerURL
+	}
+	return ""
+}
+
+func (m *memoryTransport) Publish(ctx context.Context, topic string, qos uint8, retained bool, payload []byte) error {
+	if err := ctx.Err(); nil != err {
+		return err
+	}
+	if !m.IsConnected() {
+		return ErrTransportNotConnected
+	}
+	m.broker.publish(topic, append([]byte{}, payload...), retained)
+	return nil
+}
+
+// Subscribe 订阅Topic。未连接时记录订阅，并在连接成功后生效。
+func (m *memoryTransport) Subscribe(topic string, qos uint8, handler TransportHandler) error {
+	m.mutex.Lock()
+	m.subs[topic] = handler
+	connected, inbox := m.connected, m.inbox
+	m.mutex.Unlock()
+	if connected {
+		inbox.put(m.retainedDeliveries(topic, handler)...)
+	}
+	return nil
+}
+
+func (m *memoryTransport) Unsubscribe(topics ...string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, topic := range topics {
+		delete(m.subs, topic)
+	}
+	return nil
+}
+
+// retainedDeliveries 返回订阅匹配的Retained消息
+func (m *memoryTransport) retainedDeliveries(filter string, handler TransportHandler) []*memoryDelivery {
+	matched := m.broker.matchRetained(filter)
+	deliveries := make([]*memoryDelivery, 0, len(matched))
+	for topic, payload := range matched {
+		deliveries = append(deliveries, &memoryDelivery{handler: handler, topic: topic, payload: payload})
+	}
+	return deliveries
+}
+
+// deliver 将消息投递到匹配订阅的处理函数
+func (m *memoryTransport) deliver(topic string, payload []byte) {
+	m.mutex.RLock()
+	if !m.connected {
+		m.mutex.RUnlock()
+		return
+	}
+	inbox := m.inbox
+	deliveries := make([]*memoryDelivery, 0, 1)
+	for filter, handler := range m.subs {
+		if topicMatch(filter, topic) {
+			deliveries = append(deliveries, &memoryDelivery{handler: handler, topic: topic, payload: payload})
+		}
+	}
+	m.mutex.RUnlock()
+	inbox.put(deliveries...)
+}
@@ -0,0 +1,345 @@
+package edgex
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/eclipse/paho.mqtt.golang"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+const (
+	// 连接失败的Broker在此时长内排在健康Broker之后
+	mqttBrokerCooldown = time.Minute
+	// 等待MQTT操作完成期间，检查ctx是否取消的间隔
+	mqttTokenPollInterval = time.Millisecond * 100
+)
+
+// NewMqttTransport 创建基于Paho MQTT客户端的Transport对象。
+// 配置多个Broker时，按配置顺序连接，并在断线重连时优先选择最近未出错的Broker。
+// Globals.MqttTLSConfig 为nil时，根据TLS证书文件参数创建TLS配置；创建出错时Connect返回该错误。
+func NewMqttTransport(clientId string, globals *Globals) Transport {
+	brokers := make([]*mqttBroker, 0)
+	for _, url := range mqttBrokerList(globals) {
+		brokers = append(brokers, &mqttBroker{url: url})
+	}
+	tlsConfig, tlsErr := globals.MqttTLSConfig, error(nil)
+	if nil == tlsConfig {
+		tlsConfig, tlsErr = mqttTLSConfig(globals)
+	}
+	return &mqttTransport{
+		globals:   globals,
+		clientId:  clientId,
+		brokers:   brokers,
+		subs:      make(map[string]*mqttSubscription),
+		tlsConfig: tlsConfig,
+		tlsErr:    tlsErr,
+		newClient: mqtt.NewClient,
+	}
+}
+
+// mqttBrokerList 返回Broker列表。MqttBrokers为空时，使用逗号分隔的MqttBroker。
+func mqttBrokerList(globals *Globals) []string {
+	if 0 < len(globals.MqttBrokers) {
+		return globals.MqttBrokers
+	}
+	return splitBrokers(globals.MqttBroker)
+}
+
+func splitBrokers(brokers string) []string {
+	out := make([]string, 0)
+	for _, url := range strings.Split(brokers, ",") {
+		if url = strings.TrimSpace(url); "" != url {
+			out = append(out, url)
+		}
+	}
+	return out
+}
+
+// mqttBroker Broker地址及健康状态
+type mqttBroker struct {
+	url         string
+	failures    int
+	lastFailure time.Time
+}
+
+func (b *mqttBroker) healthy(now time.Time) bool {
+	return 0 == b.failures || now.Sub(b.lastFailure) > mqttBrokerCooldown
+}
+
+type mqttSubscription struct {
+	qos     uint8
+	handler TransportHandler
+}
+
+type mqttWill struct {
+	topic    string
+	payload  []byte
+	qos      uint8
+	retained bool
+}
+
+type mqttTransport struct {
+	globals     *Globals
+	clientId    string
+	will        *mqttWill
+	onConnected func()
+	mutex       sync.RWMutex
+	brokers     []*mqttBroker
+	client      mqtt.Client
+	current     *mqttBroker
+	subs        map[string]*mqttSubscription
+	closed      bool
+	tlsConfig   *tls.Config
+	tlsErr      error
+	newClient   func(opts *mqtt.ClientOptions) mqtt.Client
+}
+
+func (m *mqttTransport) SetWill(topic string, payload []byte, qos uint8, retained bool) {
+	m.will = &mqttWill{topic: topic, payload: payload, qos: qos, retained: retained}
+}
+
+func (m *mqttTransport) OnConnected(handler func()) {
+	m.onConnected = handler
+}
+
+// Connect 按健康状态顺序逐个尝试连接Broker，全部失败时返回最后一个错误
+func (m *mqttTransport) Connect() error {
+	// 仅由调用方显式连接时重置关闭标记；自动重连不可恢复已关闭的连接
+	m.mutex.Lock()
+	m.closed = false
+	m.mutex.Unlock()
+	return m.connect()
+}
+
+func (m *mqttTransport) connect() error {
+	if nil != m.tlsErr {
+		return m.tlsErr
+	}
+	var lastErr error = ErrTransportNotConnected
+	for _, broker := range m.orderedBrokers() {
+		if err := m.connectBroker(broker); nil != err {
+			log.Debugf("Mqtt客户端：连接Broker(%s)失败：%v", broker.url, err)
+			lastErr = err
+		} else {
+			return nil
+		}
+	}
+	return lastErr
+}
+
+func (m *mqttTransport) connectBroker(broker *mqttBroker) error {
+	opts := mqtt.NewClientOptions()
+	opts.SetClientID(m.clientId)
+	opts.AddBroker(broker.url)
+	if nil != m.will {
+		opts.SetBinaryWill(m.will.topic, m.will.payload, m.will.qos, m.will.retained)
+	}
+	if nil != m.tlsConfig {
+		opts.SetTLSConfig(m.tlsConfig)
+	}
+	mqttSetOptions(opts, m.globals, func(client mqtt.Client) {
+		// OnConnect回调可能先于Connect返回执行
+		if !m.setConnected(client, broker) {
+			return
+		}
+		log.Infof("Mqtt客户端：已连接Broker= %s", broker.url)
+		m.resubscribe(client)
+		if nil != m.onConnected {
+			m.onConnected()
+		}
+	}, func(err error) {
+		m.connectionLost(broker)
+	})
+	client := m.newClient(opts)
+	token := client.Connect()
+	if token.Wait() && nil != token.Error() {
+		m.markFailure(broker)
+		return token.Error()
+	}
+	// 连接期间Transport已被关闭时，断开新建立的连接
+	if !m.setConnected(client, broker) {
+		client.Disconnect(0)
+		return ErrTransportNotConnected
+	}
+	return nil
+}
+
+// setConnected 记录已连接的客户端；Transport已关闭时返回false
+func (m *mqttTransport) setConnected(client mqtt.Client, broker *mqttBroker) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.closed {
+		return false
+	}
+	broker.failures = 0
+	m.client = client
+	m.current = broker
+	return true
+}
+
+// connectionLost 标记Broker出错，并按健康状态顺序重新连接
+func (m *mqttTransport) connectionLost(broker *mqttBroker) {
+	m.markFailure(broker)
+	m.mutex.Lock()
+	m.current = nil
+	closed := m.closed
+	m.mutex.Unlock()
+	if closed || !m.globals.MqttAutoReconnect {
+		return
+	}
+	go m.reconnect()
+}
+
+func (m *mqttTransport) reconnect() {
+	interval := m.globals.MqttReconnectInterval
+	if 0 >= interval {
+		interval = time.Second
+	}
+	for {
+		m.mutex.RLock()
+		closed := m.closed
+		m.mutex.RUnlock()
+		if closed {
+			return
+		}
+		if err := m.connect(); nil == err {
+			return
+		}
+		log.Debugf("Mqtt客户端：全部Broker重连失败，%s后重试", interval)
+		<-time.After(interval)
+	}
+}
+
+func (m *mqttTransport) markFailure(broker *mqttBroker) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	broker.failures++
+	broker.lastFailure = time.Now()
+}
+
+// orderedBrokers 返回按健康状态排序的Broker列表：健康的Broker保持配置顺序在前，最近出错的Broker在后
+func (m *mqttTransport) orderedBrokers() []*mqttBroker {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	now := time.Now()
+	ordered := append([]*mqttBroker{}, m.brokers...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		hi, hj := ordered[i].healthy(now), ordered[j].healthy(now)
+		if hi != hj {
+			return hi
+		}
+		return !hi && ordered[i].lastFailure.Before(ordered[j].lastFailure)
+	})
+	return ordered
+}
+
+// resubscribe 重连后恢复订阅
+func (m *mqttTransport) resubscribe(client mqtt.Client) {
+	m.mutex.RLock()
+	subs := make(map[string]*mqttSubscription, len(m.subs))
+	for topic, sub := range m.subs {
+		subs[topic] = sub
+	}
+	m.mutex.RUnlock()
+	for topic, sub := range subs {
+		if err := m.subscribeClient(client, topic, sub); nil != err {
+			log.Errorf("Mqtt客户端：恢复订阅Topic(%s)出错：%v", topic, err)
+		}
+	}
+}
+
+func (m *mqttTransport) subscribeClient(client mqtt.Client, topic string, sub *mqttSubscription) error {
+	token := client.Subscribe(topic, sub.qos, func(cli mqtt.Client, msg mqtt.Message) {
+		sub.handler(msg.Topic(), msg.Payload())
+	})
+	return m.awaitToken(context.Background(), token)
+}
+
+// awaitToken 等待MQTT操作完成，ctx被取消或超时时返回ctx的错误。
+// ctx未设置Deadline时，使用 Globals.MqttPublishTimeout 作为超时时间，避免半开连接时永久阻塞。
+func (m *mqttTransport) awaitToken(ctx context.Context, token mqtt.Token) error {
+	if _, ok := ctx.Deadline(); !ok && 0 < m.globals.MqttPublishTimeout {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.globals.MqttPublishTimeout)
+		defer cancel()
+	}
+	for !token.WaitTimeout(mqttTokenPollInterval) {
+		if err := ctx.Err(); nil != err {
+			return err
+		}
+	}
+	return token.Error()
+}
+
+func (m *mqttTransport) connectedClient() mqtt.Client {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if nil == m.client || nil == m.current || !m.client.IsConnected() {
+		return nil
+	}
+	return m.client
+}
+
+func (m *mqttTransport) CurrentBroker() string {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	if nil == m.current {
+		return ""
+	}
+	return m.current.url
+}
+
+func (m *mqttTransport) Disconnect(quiesceMillSec uint) {
+	m.mutex.Lock()
+	m.closed = true
+	client := m.client
+	m.current = nil
+	m.mutex.Unlock()
+	if nil != client && client.IsConnected() {
+		client.Disconnect(quiesceMillSec)
+	}
+}
+
+func (m *mqttTransport) IsConnected() bool {
+	return nil != m.connectedClient()
+}
+
+func (m *mqttTransport) Publish(ctx context.Context, topic string, qos uint8, retained bool, payload []byte) error {
+	client := m.connectedClient()
+	if nil == client {
+		return ErrTransportNotConnected
+	}
+	return m.awaitToken(ctx, client.Publish(topic, qos, retained, payload))
+}
+
+// Subscribe 订阅Topic。未连接时记录订阅，并在连接成功后生效。
+func (m *mqttTransport) Subscribe(topic string, qos uint8, handler TransportHandler) error {
+	sub := &mqttSubscription{qos: qos, handler: handler}
+	m.mutex.Lock()
+	m.subs[topic] = sub
+	m.mutex.Unlock()
+	if client := m.connectedClient(); nil != client {
+		return m.subscribeClient(client, topic, sub)
+	}
+	return nil
+}
+
+func (m *mqttTransport) Unsubscribe(topics ...string) error {
+	m.mutex.Lock()
+	for _, topic := range topics {
+		delete(m.subs, topic)
+	}
+	m.mutex.Unlock()
+	client := m.connectedClient()
+	if nil == client {
+		return nil
+	}
+	return m.awaitToken(context.Background(), client.Unsubscribe(topics...))
+}
@@ -0,0 +1,165 @@
+package edgex
+
+import (
+	"context"
+	"github.com/eclipse/paho.mqtt.golang"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func TestMqttBrokerList(t *testing.T) {
+	check := func(globals *Globals, excepted []string) {
+		if was := mqttBrokerList(globals); !reflect.DeepEqual(excepted, was) {
+			t.Errorf("Brokers not match, except: %v, was: %v", excepted, was)
+		}
+	}
+	check(&Globals{MqttBroker: "tcp://a:1883"}, []string{"tcp://a:1883"})
+	check(&Globals{MqttBroker: "tcp://a:1883, ,ssl://b:8883"}, []string{"tcp://a:1883", "ssl://b:8883"})
+	check(&Globals{MqttBroker: "tcp://a:1883", MqttBrokers: []string{"tcp://c:1883"}}, []string{"tcp://c:1883"})
+}
+
+func TestMqttBrokerOrder(t *testing.T) {
+	m := NewMqttTransport("test", &Globals{MqttBroker: "tcp://a,tcp://b,tcp://c"}).(*mqttTransport)
+	urls := func() []string {
+		out := make([]string, 0)
+		for _, b := range m.orderedBrokers() {
+			out = append(out, b.url)
+		}
+		return out
+	}
+	if excepted := []string{"tcp://a", "tcp://b", "tcp://c"}; !reflect.DeepEqual(excepted, urls()) {
+		t.Errorf("Order not match, except: %v, was: %v", excepted, urls())
+	}
+
+	m.markFailure(m.brokers[1])
+	m.markFailure(m.brokers[0])
+	if excepted := []string{"tcp://c", "tcp://b", "tcp://a"}; !reflect.DeepEqual(excepted, urls()) {
+		t.Errorf("Order not match, except: %v, was: %v", excepted, urls())
+	}
+
+	// Cooldown passed
+	m.brokers[0].lastFailure = time.Now().Add(-mqttBrokerCooldown * 2)
+	if excepted := []string{"tcp://a", "tcp://c", "tcp://b"}; !reflect.DeepEqual(excepted, urls()) {
+		t.Errorf("Order not match, except: %v, was: %v", excepted, urls())
+	}
+}
+
+// pendingToken 模拟半开连接时永不完成的Token
+type pendingToken struct{}
+
+func (pendingToken) Wait() bool {
+	select {}
+}
+
+func (pendingToken) WaitTimeout(d time.Duration) bool {
+	time.Sleep(d)
+	return false
+}
+
+func (pendingToken) Error() error {
+	return nil
+}
+
+func TestMqttAwaitTokenTimeout(t *testing.T) {
+	transport := NewMqttTransport("test", &Globals{MqttPublishTimeout: time.Millisecond * 200}).(*mqttTransport)
+	start := time.Now()
+	if err := transport.awaitToken(context.Background(), pendingToken{}); context.DeadlineExceeded != err {
+		t.Error("Should timeout, was: ", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("Timeout not applied, elapsed: ", elapsed)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := transport.awaitToken(ctx, pendingToken{}); context.Canceled != err {
+		t.Error("Should be cancelled, was: ", err)
+	}
+}
+
+// gatedToken 在gate关闭后完成的Token
+type gatedToken struct {
+	gate chan struct{}
+}
+
+func (t gatedToken) Wait() bool {
+	<-t.gate
+	return true
+}
+
+func (t gatedToken) WaitTimeout(d time.Duration) bool {
+	select {
+	case <-t.gate:
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+func (gatedToken) Error() error {
+	return nil
+}
+
+// gatedClient 连接操作阻塞到gate关闭后成功的MQTT客户端
+type gatedClient struct {
+	mqtt.Client
+	attempting chan struct{}
+	gate       chan struct{}
+	connected  int32
+}
+
+func (c *gatedClient) Connect() mqtt.Token {
+	c.attempting <- struct{}{}
+	atomic.StoreInt32(&c.connected, 1)
+	return gatedToken{gate: c.gate}
+}
+
+func (c *gatedClient) IsConnected() bool {
+	return 1 == atomic.LoadInt32(&c.connected)
+}
+
+func (c *gatedClient) Disconnect(quiesce uint) {
+	atomic.StoreInt32(&c.connected, 0)
+}
+
+func TestMqttReconnectAfterDisconnect(t *testing.T) {
+	transport := NewMqttTransport("test", &Globals{
+		MqttBroker:            "tcp://a",
+		MqttAutoReconnect:     true,
+		MqttReconnectInterval: time.Millisecond * 10,
+	}).(*mqttTransport)
+	client := &gatedClient{attempting: make(chan struct{}), gate: make(chan struct{})}
+	var attempts int32
+	transport.newClient = func(opts *mqtt.ClientOptions) mqtt.Client {
+		atomic.AddInt32(&attempts, 1)
+		return client
+	}
+
+	// 重连进行中时关闭Transport
+	transport.connectionLost(transport.brokers[0])
+	<-client.attempting
+	transport.Disconnect(0)
+	close(client.gate)
+
+	time.Sleep(time.Millisecond * 100)
+	if transport.IsConnected() {
+		t.Error("Transport should stay disconnected after Disconnect")
+	}
+	if client.IsConnected() {
+		t.Error("Client connected during reconnect should be disconnected")
+	}
+	if n := atomic.LoadInt32(&attempts); 1 != n {
+		t.Error("Reconnect should stop after Disconnect, attempts: ", n)
+	}
+	transport.mutex.RLock()
+	closed := transport.closed
+	transport.mutex.RUnlock()
+	if !closed {
+		t.Error("Reconnect should not reset closed flag")
+	}
+}
@@ -0,0 +1,184 @@
+package edgex
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func TestTopicMatch(t *testing.T) {
+	check := func(filter, topic string, excepted bool) {
+		if excepted != topicMatch(filter, topic) {
+			t.Errorf("Match not match, filter: %s, topic: %s, except: %v", filter, topic, excepted)
+		}
+	}
+	check("$EdgeX/events/a/b", "$EdgeX/events/a/b", true)
+	check("$EdgeX/events/a", "$EdgeX/events/a/b", false)
+	check("$EdgeX/events/+", "$EdgeX/events/a", true)
+	check("$EdgeX/events/+", "$EdgeX/events/a/b", false)
+	check("$EdgeX/events/+/b", "$EdgeX/events/a/b", true)
+	check("$EdgeX/events/#", "$EdgeX/events/a/b", true)
+	check("$EdgeX/events/#", "$EdgeX/events", true)
+	check("$EdgeX/values/#", "$EdgeX/events/a", false)
+	check("$EdgeX/requests/NODE/+", "$EdgeX/requests/NODE/CALLER", true)
+}
+
+func TestMemoryTransport(t *testing.T) {
+	broker := NewMemoryBroker()
+	pub := broker.Transport("pub", nil)
+	sub := broker.Transport("sub", nil)
+
+	if err := pub.Publish(context.Background(), "a/b", 0, false, nil); ErrTransportNotConnected != err {
+		t.Error("Publish before connect should fail, was: ", err)
+	}
+	connected := false
+	sub.OnConnected(func() {
+		connected = true
+	})
+	_ = pub.Connect()
+	_ = sub.Connect()
+	if !connected || !sub.IsConnected() {
+		t.Fatal("Transport not connected")
+	}
+
+	received := make(chan string, 4)
+	if err := sub.Subscribe("a/+", 0, func(topic string, payload []byte) {
+		received <- topic + "=" + string(payload)
+	}); nil != err {
+		t.Fatal("Subscribe failed: ", err)
+	}
+	_ = pub.Publish(context.Background(), "a/b", 0, false, []byte("1"))
+	_ = pub.Publish(context.Background(), "x/b", 0, false, []byte("2"))
+	_ = pub.Publish(context.Background(), "a/c", 0, false, []byte("3"))
+
+	for _, excepted := range []string{"a/b=1", "a/c=3"} {
+		select {
+		case was := <-received:
+			if excepted != was {
+				t.Errorf("Delivery not match, except: %s, was: %s", excepted, was)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Delivery timeout, except: ", excepted)
+		}
+	}
+
+	_ = sub.Unsubscribe("a/+")
+	_ = pub.Publish(context.Background(), "a/b", 0, false, []byte("4"))
+	sub.Disconnect(0)
+	select {
+	case was := <-received:
+		t.Error("Unexpected delivery: ", was)
+	case <-time.After(time.Millisecond * 50):
+	}
+}
+
+func TestMemoryBrokerRetainedAndWill(t *testing.T) {
+	broker := NewMemoryBroker()
+	node := broker.Transport("node", nil)
+	node.SetWill("states/node", []byte("OFFLINE"), 0, true)
+	monitor := broker.Transport("monitor", nil)
+	_ = node.Connect()
+	_ = monitor.Connect()
+
+	_ = node.Publish(context.Background(), "states/node", 0, true, []byte("ALIVE"))
+	_ = node.Publish(context.Background(), "values/node", 0, false, []byte("1"))
+
+	received := make(chan string, 4)
+	_ = monitor.Subscribe("+/node", 0, func(topic string, payload []byte) {
+		received <- topic + "=" + string(payload)
+	})
+	expect := func(excepted string) {
+		select {
+		case was := <-received:
+			if excepted != was {
+				t.Errorf("Delivery not match, except: %s, was: %s", excepted, was)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Delivery timeout, except: ", excepted)
+		}
+	}
+	// Retained delivered on subscribe
+	expect("states/node=ALIVE")
+
+	// Will delivered on abnormal disconnect
+	if !broker.Kick("node") || node.IsConnected() {
+		t.Fatal("Kick failed")
+	}
+	expect("states/node=OFFLINE")
+	if payload, ok := broker.Retained("states/node"); !ok || "OFFLINE" != string(payload) {
+		t.Error("Retained will not match, was: ", string(payload))
+	}
+
+	// Empty retained payload clears
+	_ = monitor.Publish(context.Background(), "states/node", 0, true, nil)
+	expect("states/node=")
+	if _, ok := broker.Retained("states/node"); ok {
+		t.Error("Retained should be cleared")
+	}
+}
+
+func TestMemoryTransportSubscribeBeforeConnect(t *testing.T) {
+	broker := NewMemoryBroker()
+	pub := broker.Transport("pub", nil)
+	sub := broker.Transport("sub", nil)
+	_ = pub.Connect()
+	_ = pub.Publish(context.Background(), "states/a", 0, true, []byte("ALIVE"))
+
+	// 未连接时记录订阅，连接后投递Retained消息及后续消息
+	received := make(chan string, 4)
+	if err := sub.Subscribe("states/+", 0, func(topic string, payload []byte) {
+		received <- topic + "=" + string(payload)
+	}); nil != err {
+		t.Fatal("Subscribe before connect should succeed, was: ", err)
+	}
+	if err := sub.Unsubscribe("values/+"); nil != err {
+		t.Error("Unsubscribe before connect should succeed, was: ", err)
+	}
+	_ = sub.Connect()
+	_ = pub.Publish(context.Background(), "states/b", 0, false, []byte("1"))
+	for _, excepted := range []string{"states/a=ALIVE", "states/b=1"} {
+		select {
+		case was := <-received:
+			if excepted != was {
+				t.Errorf("Delivery not match, except: %s, was: %s", excepted, was)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Delivery timeout, except: ", excepted)
+		}
+	}
+	sub.Disconnect(0)
+	pub.Disconnect(0)
+}
+
+func TestMemoryTransportHandlerPublishBack(t *testing.T) {
+	broker := NewMemoryBroker()
+	transport := broker.Transport("echo", nil)
+	_ = transport.Connect()
+	defer transport.Disconnect(0)
+
+	// 处理函数向自身订阅的Topic连续发布大量消息，不应阻塞投递协程
+	const count = 4096
+	finished := make(chan struct{})
+	_ = transport.Subscribe("echo/#", 0, func(topic string, payload []byte) {
+		if "echo/start" != topic {
+			if "echo/last" == topic {
+				close(finished)
+			}
+			return
+		}
+		for i := 0; i < count; i++ {
+			_ = transport.Publish(context.Background(), "echo/next", 0, false, nil)
+		}
+		_ = transport.Publish(context.Background(), "echo/last", 0, false, nil)
+	})
+	_ = transport.Publish(context.Background(), "echo/start", 0, false, nil)
+	select {
+	case <-finished:
+	case <-time.After(time.Second * 2):
+		t.Fatal("Handler publish back deadlocked")
+	}
+}
@@ -3,7 +3,8 @@ package edgex
 import (
 	"context"
 	"github.com/bwmarrin/snowflake"
-	"github.com/eclipse/paho.mqtt.golang"
+	"sync"
+	"time"
 )
 
 //
@@ -18,30 +19,77 @@ type Trigger interface {
 	NeedProperties
 
 	// 发送MQTT消息
+	// 启用离线队列(Globals.OfflineQueueDir)时，连接断开期间的消息写入队列，重新连接后按顺序重发。
+	// 发送超时时间为 Globals.MqttPublishTimeout。
 	PublishMqtt(mqttTopic string, message Message, qos uint8, retained bool) error
 
-	// PublishEvent 发送虚拟节点的Event消息。发送消息的QoS使用默认设置。
-	PublishEvent(boardId, majorId, minorId string, data []byte, eventId int64) error
+	// PublishMqttCtx 发送MQTT消息，ctx被取消或超时时返回ctx的错误
+	PublishMqttCtx(ctx context.Context, mqttTopic string, message Message, qos uint8, retained bool) error
+
+	// PublishEvent 发送虚拟节点的Event消息。
+	// 发送参数使用 TriggerOptions.EventPublish 设置，可通过opts覆盖单次发送的QoS、Retained及超时时间。
+	PublishEvent(boardId, majorId, minorId string, data []byte, eventId int64, opts ...PublishOption) error
+
+	// PublishEventCtx 发送虚拟节点的Event消息，ctx被取消或超时时返回ctx的错误
+	PublishEventCtx(ctx context.Context, boardId, majorId, minorId string, data []byte, eventId int64, opts ...PublishOption) error
 
 	// PublishEventMessage 发送虚拟节点的Event消息。
-	PublishEventMessage(message Message) error
+	PublishEventMessage(message Message, opts ...PublishOption) error
+
+	// PublishEventMessageCtx 发送虚拟节点的Event消息，ctx被取消或超时时返回ctx的错误
+	PublishEventMessageCtx(ctx context.Context, message Message, opts ...PublishOption) error
+
+	// PublishValue 发送虚拟节点的Value消息。发送参数使用 TriggerOptions.ValuePublish 设置，可通过opts覆盖。
+	PublishValue(boardId, majorId, minorId string, data []byte, eventId int64, opts ...PublishOption) error
+
+	// PublishValueCtx 发送虚拟节点的Value消息，ctx被取消或超时时返回ctx的错误
+	PublishValueCtx(ctx context.Context, boardId, majorId, minorId string, data []byte, eventId int64, opts ...PublishOption) error
+
+	// PublishValueMessage 发送虚拟节点的Value消息。
+	// 设置 TriggerOptions.ValueBatchWindow 时，消息缓存到时间窗口结束，由异步发送协程发送，不阻塞调用方：
+	// 返回值仅表示消息已加入合并队列，发送出错时记录日志；Trigger已停止时返回 ErrTriggerStopped。
+	// 同时设置 TriggerOptions.ValueCoalesce 时，同一虚拟节点在时间窗口内只发送最新的消息，
+	// 较早的消息被丢弃且不返回错误，丢弃数量由 CoalescedValues 返回。
+	PublishValueMessage(message Message, opts ...PublishOption) error
+
+	// PublishValueMessageCtx 发送虚拟节点的Value消息，ctx被取消或超时时返回ctx的错误；
+	// 合并发送时，ctx已取消或超时的消息不加入合并队列
+	PublishValueMessageCtx(ctx context.Context, message Message, opts ...PublishOption) error
+
+	// CoalescedValues 返回设置 TriggerOptions.ValueCoalesce 时，被同一虚拟节点较新消息替换而丢弃的消息数量
+	CoalescedValues() uint64
 
-	// PublishValue 发送虚拟节点的Value消息。发送消息的QoS使用默认设置。
-	PublishValue(boardId, majorId, minorId string, data []byte, eventId int64) error
+	// PublishValueAsync 异步发送虚拟节点的Value消息
+	PublishValueAsync(message Message, opts ...PublishOption) PublishFuture
 
-	// PublishValueMessage 发送虚拟节点的Value消息
-	PublishValueMessage(message Message) error
+	// PublishAsync 异步发送MQTT消息，立即返回 PublishFuture。
+	// 异步消息由单一协程按提交顺序发送；等待队列已满时按 TriggerOptions.PublishOverflowPolicy 处理。
+	PublishAsync(mqttTopic string, message Message, qos uint8, retained bool) PublishFuture
 
-	// PublishAction 发送虚拟节点的Action发送消息的QoS使用默认设置。
-	PublishAction(boardId, majorId, minorId string, data []byte, eventId int64) error
+	// PublishAction 发送虚拟节点的Action消息。发送参数使用 TriggerOptions.ActionPublish 设置，可通过opts覆盖。
+	PublishAction(boardId, majorId, minorId string, data []byte, eventId int64, opts ...PublishOption) error
+
+	// PublishActionCtx 发送虚拟节点的Action消息，ctx被取消或超时时返回ctx的错误
+	PublishActionCtx(ctx context.Context, boardId, majorId, minorId string, data []byte, eventId int64, opts ...PublishOption) error
 
 	// PublishActionMessage 发送虚拟节点的Action发送消息的QoS使用默认设置。
-	PublishActionMessage(message Message) error
+	PublishActionMessage(message Message, opts ...PublishOption) error
+
+	// PublishActionMessageCtx 发送虚拟节点的Action消息，ctx被取消或超时时返回ctx的错误
+	PublishActionMessageCtx(ctx context.Context, message Message, opts ...PublishOption) error
 }
 
 type TriggerOptions struct {
-	Topic              string                    // 触发器发送事件的主题
-	NodePropertiesFunc func() MainNodeProperties // Inspect消息生成函数
+	Topic                 string                    // 触发器发送事件的主题
+	NodePropertiesFunc    func() MainNodeProperties // Inspect消息生成函数
+	PublishQueueSize      int                       // 异步发送等待队列长度，为0时使用默认值 DefaultPublishQueueSize
+	PublishOverflowPolicy OverflowPolicy            // 异步发送等待队列已满时的处理策略，默认为阻塞等待
+	ValueBatchWindow      time.Duration             // Value消息合并发送的时间窗口，窗口结束时批量发送；为0时不合并
+	ValueCoalesce         bool                      // 合并发送时，时间窗口内同一虚拟节点只发送最新的消息，较早的消息被丢弃
+	// 各类别消息的默认发送参数，为nil时使用Globals的MqttQoS及MqttRetained设置；参数无效时NewTrigger抛出Panic
+	EventPublish  *PublishOptions
+	ValuePublish  *PublishOptions
+	ActionPublish *PublishOptions
 }
 
 //// trigger
@@ -53,10 +101,25 @@ type trigger struct {
 	globals    *Globals
 	eventIdRef *snowflake.Node // Trigger产生的消息ID序列
 	// MQTT
-	mqttRef            mqtt.Client
+	transport          Transport
+	offline            *offlineQueue // 离线消息队列，未启用时为nil
+	retainedTopics     *sync.Map     // 已发送的节点状态及属性保留消息Topic
+	announcers         *sync.Map     // Context中注册的Properties上报函数
+	clock              Clock
 	mqttPubEventTopic  string // MQTT使用的EventTopic
 	mqttPubValueTopic  string // MQTT使用的ValueTopic
 	mqttPubActionTopic string // MQTT使用的ActionTopic
+	// Async
+	publishQueue    chan *publishTask
+	publishDone     chan struct{}
+	publishMutex    sync.RWMutex
+	publishStopped  bool
+	batchMutex      sync.Mutex
+	batchTasks      []*publishTask // 等待合并发送的Value消息
+	batchIndex      map[string]int // UnionId -> batchTasks中的位置，仅合并同一虚拟节点消息时使用
+	batchCancel     context.CancelFunc
+	batchDone       chan struct{}
+	coalescedValues uint64
 
 	// Shutdown
 	stopContext context.Context
@@ -71,8 +134,9 @@ func (t *trigger) GenerateEventId() int64 {
 	return t.eventIdRef.Generate().Int64()
 }
 
-func (t *trigger) NewMessage(boardId, majorId, minorId string, body []byte, eventId int64) Message {
-	return NewMessage(t.nodeId, boardId, majorId, minorId, body, eventId)
+func (t *trigger) NewMessage(boardId, majorId, minorId string, body []byte, eventId int64, opts ...MessageOption) Message {
+	return NewMessage(t.nodeId, boardId, majorId, minorId, body, eventId,
+		append([]MessageOption{WithFrameVersion(t.globals.FrameVersion), WithReplyTo(t.nodeId)}, opts...)...)
 }
 
 func (t *trigger) Startup() {
@@ -81,75 +145,122 @@ func (t *trigger) Startup() {
 	t.mqttPubEventTopic = TopicOfEvents(t.opts.Topic)
 	t.mqttPubValueTopic = TopicOfValues(t.opts.Topic)
 	t.mqttPubActionTopic = TopicOfActions(t.nodeId) // Action使用当前节点作为子Topic
-	// 定时发送Properties消息
-	if nil != t.opts.NodePropertiesFunc {
-		prop := t.opts.NodePropertiesFunc()
-		go scheduleSendProperties(t.stopContext, func() {
-			t.PublishNodeProperties(prop)
-		})
+	t.startPublisher()
+	// 定时发送Properties消息，每次发送时重新生成；重新连接及接收到Discovery请求时由Context触发发送
+	if nil != t.opts.NodePropertiesFunc && !t.globals.NodeStateDisabled {
+		announce := func() {
+			t.PublishNodeProperties(t.opts.NodePropertiesFunc())
+		}
+		t.announcers.Store(t, announce)
+		go scheduleSendProperties(t.stopContext, t.globals, t.clock, announce)
 	}
 }
 
 func (t *trigger) PublishNodeProperties(properties MainNodeProperties) {
 	t.checkReady()
 	properties.NodeId = t.nodeId
-	mqttSendNodeProperties(t.globals, t.mqttRef, properties)
+	mqttSendNodeProperties(t.globals, t.transport, t.retainedTopics, properties)
 }
 
 func (t *trigger) PublishNodeState(state VirtualNodeState) {
 	t.checkReady()
 	state.NodeId = t.nodeId
-	mqttSendNodeState(t.mqttRef, state)
+	mqttSendNodeState(t.globals, t.transport, t.retainedTopics, state)
+}
+
+func (t *trigger) PublishEvent(boardId, majorId, minorId string, data []byte, eventId int64, opts ...PublishOption) error {
+	return t.PublishEventCtx(context.Background(), boardId, majorId, minorId, data, eventId, opts...)
+}
+
+func (t *trigger) PublishEventCtx(ctx context.Context, boardId, majorId, minorId string, data []byte, eventId int64, opts ...PublishOption) error {
+	return t.PublishEventMessageCtx(ctx, t.NewMessage(boardId, majorId, minorId, data, eventId), opts...)
+}
+
+func (t *trigger) PublishEventMessage(message Message, opts ...PublishOption) error {
+	return t.PublishEventMessageCtx(context.Background(), message, opts...)
 }
 
-func (t *trigger) PublishEvent(boardId, majorId, minorId string, data []byte, eventId int64) error {
-	return t.PublishEventMessage(t.NewMessage(boardId, majorId, minorId, data, eventId))
+func (t *trigger) PublishEventMessageCtx(ctx context.Context, message Message, opts ...PublishOption) error {
+	options, err := newPublishOptions(t.globals, t.opts.EventPublish, opts)
+	if nil != err {
+		return err
+	}
+	return t.publishWith(ctx, t.mqttPubEventTopic, message, options)
+}
+
+func (t *trigger) PublishValue(boardId, majorId, minorId string, data []byte, eventId int64, opts ...PublishOption) error {
+	return t.PublishValueCtx(context.Background(), boardId, majorId, minorId, data, eventId, opts...)
+}
+
+func (t *trigger) PublishValueCtx(ctx context.Context, boardId, majorId, minorId string, data []byte, eventId int64, opts ...PublishOption) error {
+	return t.PublishValueMessageCtx(ctx, t.NewMessage(boardId, majorId, minorId, data, eventId), opts...)
+}
+
+func (t *trigger) PublishValueMessage(message Message, opts ...PublishOption) error {
+	return t.PublishValueMessageCtx(context.Background(), message, opts...)
+}
+
+func (t *trigger) PublishValueMessageCtx(ctx context.Context, message Message, opts ...PublishOption) error {
+	options, err := newPublishOptions(t.globals, t.opts.ValuePublish, opts)
+	if nil != err {
+		return err
+	}
+	if 0 < t.opts.ValueBatchWindow {
+		t.checkReady()
+		if err := ctx.Err(); nil != err {
+			return err
+		}
+		return t.batchValue(message, options)
+	}
+	return t.publishWith(ctx, t.mqttPubValueTopic, message, options)
 }
 
-func (t *trigger) PublishEventMessage(message Message) error {
-	return t.PublishMqtt(
-		t.mqttPubEventTopic,
-		message,
-		t.globals.MqttQoS, t.globals.MqttRetained)
+func (t *trigger) PublishAction(boardId, majorId, minorId string, data []byte, eventId int64, opts ...PublishOption) error {
+	return t.PublishActionCtx(context.Background(), boardId, majorId, minorId, data, eventId, opts...)
 }
 
-func (t *trigger) PublishValue(boardId, majorId, minorId string, data []byte, eventId int64) error {
-	return t.PublishValueMessage(t.NewMessage(boardId, majorId, minorId, data, eventId))
+func (t *trigger) PublishActionCtx(ctx context.Context, boardId, majorId, minorId string, data []byte, eventId int64, opts ...PublishOption) error {
+	return t.PublishActionMessageCtx(ctx, t.NewMessage(boardId, majorId, minorId, data, eventId), opts...)
 }
 
-func (t *trigger) PublishValueMessage(message Message) error {
-	return t.PublishMqtt(
-		t.mqttPubValueTopic,
-		message,
-		t.globals.MqttQoS, t.globals.MqttRetained)
+func (t *trigger) PublishActionMessage(message Message, opts ...PublishOption) error {
+	return t.PublishActionMessageCtx(context.Background(), message, opts...)
 }
 
-func (t *trigger) PublishAction(boardId, majorId, minorId string, data []byte, eventId int64) error {
-	return t.PublishActionMessage(t.NewMessage(boardId, majorId, minorId, data, eventId))
+func (t *trigger) PublishActionMessageCtx(ctx context.Context, message Message, opts ...PublishOption) error {
+	options, err := newPublishOptions(t.globals, t.opts.ActionPublish, opts)
+	if nil != err {
+		return err
+	}
+	return t.publishWith(ctx, t.mqttPubActionTopic, message, options)
 }
 
-func (t *trigger) PublishActionMessage(message Message) error {
-	return t.PublishMqtt(
-		t.mqttPubActionTopic,
-		message,
-		t.globals.MqttQoS, t.globals.MqttRetained)
+// publishWith 按发送参数发送消息
+func (t *trigger) publishWith(ctx context.Context, mqttTopic string, message Message, options PublishOptions) error {
+	ctx, cancel := withPublishTimeout(ctx, options)
+	defer cancel()
+	return t.PublishMqttCtx(ctx, mqttTopic, message, options.QoS, options.Retained)
 }
 
 func (t *trigger) PublishMqtt(mqttTopic string, message Message, qos uint8, retained bool) error {
+	return t.PublishMqttCtx(context.Background(), mqttTopic, message, qos, retained)
+}
+
+func (t *trigger) PublishMqttCtx(ctx context.Context, mqttTopic string, message Message, qos uint8, retained bool) error {
 	t.checkReady()
-	token := t.mqttRef.Publish(
+	if nil != t.offline {
+		return t.offline.publish(ctx, t.transport, mqttTopic, qos, retained, message.Bytes())
+	}
+	return t.transport.Publish(ctx,
 		mqttTopic,
 		qos,
 		retained,
 		message.Bytes())
-	if token.Wait() && nil != token.Error() {
-		return token.Error()
-	} else {
-		return nil
-	}
 }
 
 func (t *trigger) Shutdown() {
+	t.announcers.Delete(t)
+	t.stopPublisher()
 	t.stopCancel()
 }
 
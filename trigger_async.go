@@ -0,0 +1,288 @@
+package edgex
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+const (
+	DefaultPublishQueueSize = 1024
+)
+
+var (
+	ErrPublishQueueFull = errors.New("publish queue full")
+	ErrTriggerStopped   = errors.New("trigger stopped")
+)
+
+// PublishFuture 异步发送消息的结果
+type PublishFuture interface {
+	// Done 返回发送完成时关闭的通道
+	Done() <-chan struct{}
+
+	// Wait 阻塞等待发送完成，返回发送结果
+	Wait() error
+
+	// OnComplete 设置发送完成后的回调函数，回调函数在发送协程中调用，不可长时间阻塞；
+	// 已完成时立即在当前协程中调用。
+	OnComplete(callback func(err error))
+}
+
+type publishFuture struct {
+	mutex     sync.Mutex
+	done      chan struct{}
+	err       error
+	callbacks []func(err error)
+}
+
+func newPublishFuture() *publishFuture {
+	return &publishFuture{done: make(chan struct{})}
+}
+
+func (f *publishFuture) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *publishFuture) Wait() error {
+	<-f.done
+	return f.err
+}
+
+func (f *publishFuture) OnComplete(callback func(err error)) {
+	f.mutex.Lock()
+	select {
+	case <-f.done:
+		f.mutex.Unlock()
+		callback(f.err)
+	default:
+		f.callbacks = append(f.callbacks, callback)
+		f.mutex.Unlock()
+	}
+}
+
+// failedFuture 返回已完成的失败结果
+func failedFuture(err error) PublishFuture {
+	future := newPublishFuture()
+	future.complete(err)
+	return future
+}
+
+func (f *publishFuture) complete(err error) {
+	f.mutex.Lock()
+	f.err = err
+	close(f.done)
+	callbacks := f.callbacks
+	f.callbacks = nil
+	f.mutex.Unlock()
+	for _, cb := range callbacks {
+		cb(err)
+	}
+}
+
+// publishTask 等待异步发送的消息
+type publishTask struct {
+	mqttTopic string
+	message   Message
+	qos       uint8
+	retained  bool
+	timeout   time.Duration
+	future    *publishFuture
+}
+
+// startPublisher 启动异步发送协程，及Value消息的定时合并发送
+func (t *trigger) startPublisher() {
+	queueSize := t.opts.PublishQueueSize
+	if 0 >= queueSize {
+		queueSize = DefaultPublishQueueSize
+	}
+	t.publishQueue = make(chan *publishTask, queueSize)
+	t.publishDone = make(chan struct{})
+	t.publishStopped = false
+	go func() {
+		defer close(t.publishDone)
+		for task := range t.publishQueue {
+			task.future.complete(t.publishWith(context.Background(), task.mqttTopic, task.message,
+				PublishOptions{QoS: task.qos, Retained: task.retained, Timeout: task.timeout}))
+		}
+	}()
+	t.batchTasks = make([]*publishTask, 0)
+	t.batchIndex = make(map[string]int)
+	t.batchDone = make(chan struct{})
+	if 0 >= t.opts.ValueBatchWindow {
+		close(t.batchDone)
+		return
+	}
+	var batch context.Context
+	batch, t.batchCancel = context.WithCancel(context.Background())
+	go func() {
+		defer close(t.batchDone)
+		ticker := t.clock.NewTicker(t.opts.ValueBatchWindow)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C():
+				t.flushValues()
+
+			case <-batch.Done():
+				return
+			}
+		}
+	}()
+}
+
+// stopPublisher 停止定时合并发送，发送已合并的Value消息，并等待异步发送队列中的消息发送完成。
+// 重复调用时直接返回。
+func (t *trigger) stopPublisher() {
+	if nil != t.batchCancel {
+		t.batchCancel()
+	}
+	<-t.batchDone
+	t.publishMutex.Lock()
+	if t.publishStopped {
+		t.publishMutex.Unlock()
+		return
+	}
+	t.publishStopped = true
+	// 持有写锁期间不会有新的Value消息加入合并队列
+	for _, task := range t.takeBatch() {
+		t.publishQueue <- t.batchedTask(task)
+	}
+	t.publishMutex.Unlock()
+	close(t.publishQueue)
+	<-t.publishDone
+}
+
+func (t *trigger) PublishAsync(mqttTopic string, message Message, qos uint8, retained bool) PublishFuture {
+	if err := verifyPublishOptions(PublishOptions{QoS: qos}); nil != err {
+		return failedFuture(err)
+	}
+	return t.publishAsync(&publishTask{
+		mqttTopic: mqttTopic,
+		message:   message,
+		qos:       qos,
+		retained:  retained,
+	})
+}
+
+func (t *trigger) PublishValueAsync(message Message, opts ...PublishOption) PublishFuture {
+	options, err := newPublishOptions(t.globals, t.opts.ValuePublish, opts)
+	if nil != err {
+		return failedFuture(err)
+	}
+	return t.publishAsync(t.newValueTask(message, options))
+}
+
+func (t *trigger) newValueTask(message Message, options PublishOptions) *publishTask {
+	return &publishTask{
+		mqttTopic: t.mqttPubValueTopic,
+		message:   message,
+		qos:       options.QoS,
+		retained:  options.Retained,
+		timeout:   options.Timeout,
+	}
+}
+
+func (t *trigger) publishAsync(task *publishTask) PublishFuture {
+	t.checkReady()
+	future := newPublishFuture()
+	task.future = future
+	// 持有读锁期间发送队列不会被关闭
+	t.publishMutex.RLock()
+	defer t.publishMutex.RUnlock()
+	if t.publishStopped {
+		future.complete(ErrTriggerStopped)
+		return future
+	}
+	t.enqueue(task)
+	return future
+}
+
+// enqueue 按等待队列溢出策略加入发送队列。须在持有publishMutex读锁时调用。
+func (t *trigger) enqueue(task *publishTask) {
+	if OverflowReject == t.opts.PublishOverflowPolicy {
+		select {
+		case t.publishQueue <- task:
+		default:
+			task.future.complete(ErrPublishQueueFull)
+		}
+	} else {
+		t.publishQueue <- task
+	}
+}
+
+// batchValue 将Value消息加入合并队列，等待时间窗口结束时发送；Trigger已停止时返回 ErrTriggerStopped。
+// 设置 TriggerOptions.ValueCoalesce 时，时间窗口内同一虚拟节点(UnionId)只保留最新的消息，被替换的消息计入合并丢弃数量；
+// 否则保留全部消息，缓存数量达到发送队列长度时立即发送。
+func (t *trigger) batchValue(message Message, options PublishOptions) error {
+	t.publishMutex.RLock()
+	if t.publishStopped {
+		t.publishMutex.RUnlock()
+		return ErrTriggerStopped
+	}
+	t.batchMutex.Lock()
+	task := t.newValueTask(message, options)
+	full := false
+	if t.opts.ValueCoalesce {
+		unionId := message.UnionId()
+		if idx, ok := t.batchIndex[unionId]; ok {
+			t.batchTasks[idx] = task
+			atomic.AddUint64(&t.coalescedValues, 1)
+		} else {
+			t.batchIndex[unionId] = len(t.batchTasks)
+			t.batchTasks = append(t.batchTasks, task)
+		}
+	} else {
+		t.batchTasks = append(t.batchTasks, task)
+		full = len(t.batchTasks) >= cap(t.publishQueue)
+	}
+	t.batchMutex.Unlock()
+	t.publishMutex.RUnlock()
+	if full {
+		t.flushValues()
+	}
+	return nil
+}
+
+func (t *trigger) CoalescedValues() uint64 {
+	return atomic.LoadUint64(&t.coalescedValues)
+}
+
+// flushValues 按加入的顺序异步发送合并队列中的Value消息
+func (t *trigger) flushValues() {
+	t.publishMutex.RLock()
+	defer t.publishMutex.RUnlock()
+	if t.publishStopped {
+		return
+	}
+	for _, task := range t.takeBatch() {
+		t.enqueue(t.batchedTask(task))
+	}
+}
+
+// takeBatch 取出合并队列中的全部消息
+func (t *trigger) takeBatch() []*publishTask {
+	t.batchMutex.Lock()
+	defer t.batchMutex.Unlock()
+	tasks := t.batchTasks
+	t.batchTasks = make([]*publishTask, 0, len(tasks))
+	t.batchIndex = make(map[string]int, len(t.batchIndex))
+	return tasks
+}
+
+// batchedTask 设置合并发送消息的发送结果处理：发送出错时记录日志
+func (t *trigger) batchedTask(task *publishTask) *publishTask {
+	task.future = newPublishFuture()
+	unionId := task.message.UnionId()
+	task.future.OnComplete(func(err error) {
+		if nil != err {
+			log.Errorf("发送合并Value消息出错，目标：%s, 错误：%s", unionId, err)
+		}
+	})
+	return task
+}
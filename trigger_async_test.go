@@ -0,0 +1,165 @@
+package edgex
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+//
+// Author: 陈哈哈 yoojiachen@gmail.com
+//
+
+func TestTriggerPublishAsync(t *testing.T) {
+	broker := NewMemoryBroker()
+	triggerCtx := newLoopbackContext(broker, "TRIGGER")
+	defer triggerCtx.destroy()
+
+	trigger := triggerCtx.NewTrigger(TriggerOptions{Topic: "example/async"})
+	trigger.Startup()
+
+	future := trigger.PublishValueAsync(trigger.NewMessage("SENSOR", "T", "", []byte("20.5"), trigger.GenerateEventId()))
+	completed := make(chan error, 1)
+	future.OnComplete(func(err error) {
+		completed <- err
+	})
+	if err := future.Wait(); nil != err {
+		t.Error("Publish async failed: ", err)
+	}
+	select {
+	case err := <-completed:
+		if nil != err {
+			t.Error("Callback error: ", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Callback not called")
+	}
+
+	trigger.Shutdown()
+	stopped := trigger.PublishValueAsync(trigger.NewMessage("SENSOR", "T", "", nil, 0))
+	if ErrTriggerStopped != stopped.Wait() {
+		t.Error("Publish after shutdown should fail")
+	}
+}
+
+func TestTriggerValueBatch(t *testing.T) {
+	broker := NewMemoryBroker()
+	triggerCtx := newLoopbackContext(broker, "TRIGGER")
+	defer triggerCtx.destroy()
+	receiverCtx := newLoopbackContext(broker, "RECEIVER")
+	defer receiverCtx.destroy()
+
+	received := make(chan Message, 10)
+	if err := receiverCtx.SubscribeValues("example/#", func(msg Message) {
+		received <- msg
+	}); nil != err {
+		t.Fatal("Subscribe failed: ", err)
+	}
+	check := func(coalesce bool, expected []string) {
+		trigger := triggerCtx.NewTrigger(TriggerOptions{Topic: "example/batch", ValueBatchWindow: time.Hour, ValueCoalesce: coalesce})
+		trigger.Startup()
+		for _, v := range []struct{ major, body string }{{"A", "1"}, {"B", "1"}, {"A", "2"}} {
+			if err := trigger.PublishValue("SENSOR", v.major, "", []byte(v.body), trigger.GenerateEventId()); nil != err {
+				t.Fatal("Publish failed: ", err)
+			}
+		}
+		if n := trigger.CoalescedValues(); uint64(3-len(expected)) != n {
+			t.Error("Coalesced values not match, was: ", n)
+		}
+		// ctx已取消的消息不加入合并队列
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if err := trigger.PublishValueCtx(ctx, "SENSOR", "C", "", []byte("1"), 0); context.Canceled != err {
+			t.Error("Should return ctx error, was: ", err)
+		}
+		// Shutdown时发送已合并的消息，重复调用无副作用
+		trigger.Shutdown()
+		trigger.Shutdown()
+		if err := trigger.PublishValue("SENSOR", "A", "", []byte("3"), 0); ErrTriggerStopped != err {
+			t.Error("Publish after shutdown should fail, was: ", err)
+		}
+
+		for _, expected := range expected {
+			select {
+			case msg := <-received:
+				if was := msg.UnionId() + "=" + string(msg.Body()); expected != was {
+					t.Error("Batch value not match, expected: ", expected, ", was: ", was)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("Batch value not received: ", expected)
+			}
+		}
+		select {
+		case msg := <-received:
+			t.Error("Unexpected value: ", msg.UnionId())
+		case <-time.After(time.Millisecond * 50):
+		}
+	}
+	// 默认发送全部消息
+	check(false, []string{"TRIGGER:SENSOR:A:=1", "TRIGGER:SENSOR:B:=1", "TRIGGER:SENSOR:A:=2"})
+	// 同一虚拟节点只发送最新的消息
+	check(true, []string{"TRIGGER:SENSOR:A:=2", "TRIGGER:SENSOR:B:=1"})
+}
+
+func TestTriggerPublishOptions(t *testing.T) {
+	broker := NewMemoryBroker()
+	triggerCtx := newLoopbackContext(broker, "TRIGGER")
+	defer triggerCtx.destroy()
+
+	trigger := triggerCtx.NewTrigger(TriggerOptions{
+		Topic:        "example/options",
+		EventPublish: &PublishOptions{QoS: 1, Retained: true},
+	})
+	trigger.Startup()
+	defer trigger.Shutdown()
+
+	// 类别默认参数
+	if err := trigger.PublishEvent("ALARM", "FIRE", "", []byte("1"), trigger.GenerateEventId()); nil != err {
+		t.Fatal("Publish failed: ", err)
+	}
+	if _, ok := broker.Retained(TopicOfEvents("example/options")); !ok {
+		t.Error("Event should be retained")
+	}
+	// 单次发送参数覆盖默认参数
+	if err := trigger.PublishValue("SENSOR", "T", "", []byte("2"), trigger.GenerateEventId(), WithRetained(true)); nil != err {
+		t.Fatal("Publish failed: ", err)
+	}
+	if _, ok := broker.Retained(TopicOfValues("example/options")); !ok {
+		t.Error("Value should be retained")
+	}
+	if err := trigger.PublishAction("SWITCH", "1", "", []byte("ON"), trigger.GenerateEventId()); nil != err {
+		t.Fatal("Publish failed: ", err)
+	}
+	if _, ok := broker.Retained(TopicOfActions("TRIGGER")); ok {
+		t.Error("Action should not be retained")
+	}
+
+	// 无效的发送参数在发送前返回错误
+	invalidQoS := func(opts *PublishOptions) {
+		opts.QoS = 3
+	}
+	if err := trigger.PublishEvent("ALARM", "FIRE", "", []byte("3"), trigger.GenerateEventId(), invalidQoS); nil == err {
+		t.Error("Publish with invalid QoS should fail")
+	}
+	if err := trigger.PublishValueAsync(trigger.NewMessage("SENSOR", "T", "", nil, 0), invalidQoS).Wait(); nil == err {
+		t.Error("Async publish with invalid QoS should fail")
+	}
+	if err := trigger.PublishAsync(TopicOfValues("example/options"), trigger.NewMessage("SENSOR", "T", "", nil, 0), 3, false).Wait(); nil == err {
+		t.Error("Async publish with invalid QoS should fail")
+	}
+	mustPanic := func(name string, fn func()) {
+		defer func() {
+			if nil == recover() {
+				t.Errorf("%s should panic", name)
+			}
+		}()
+		fn()
+	}
+	mustPanic("Invalid ValuePublish", func() {
+		triggerCtx.NewTrigger(TriggerOptions{Topic: "example/invalid", ValuePublish: &PublishOptions{QoS: 3}})
+	})
+	mustPanic("Invalid ActionPublish", func() {
+		triggerCtx.NewTrigger(TriggerOptions{Topic: "example/invalid", ActionPublish: &PublishOptions{Timeout: -time.Second}})
+	})
+	mustPanic("Negative timeout", func() { WithPublishTimeout(-time.Second) })
+}